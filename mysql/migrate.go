@@ -0,0 +1,16 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Migrate execute the ddl statements in order, statements should be idempotent such as CREATE TABLE IF NOT EXISTS
+func Migrate(ctx context.Context, db *sql.DB, statements ...string) error {
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package admin
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/chaihaobo/gocommon/constant"
+	commonErr "github.com/chaihaobo/gocommon/error"
+	"github.com/chaihaobo/gocommon/queue"
+	"github.com/chaihaobo/gocommon/restkit"
+)
+
+const defaultPageSize = 20
+
+type (
+	// MessagesQuery 分页查询消息的参数
+	MessagesQuery struct {
+		State    queue.MessageState `form:"state" binding:"required,oneof=pending active scheduled retry dead"`
+		Page     int                `form:"page" binding:"omitempty,min=1"`
+		PageSize int                `form:"page_size" binding:"omitempty,min=1,max=1000"`
+	}
+
+	handler struct {
+		inspector queue.Inspector
+	}
+)
+
+// RegisterRoutes 在 router 中注册队列管理接口. 响应使用 restkit 的格式
+//
+//	GET    /topics                                  主题以及各个状态的消息数量
+//	GET    /topics/:topic/messages?state=&page=     分页查询消息
+//	DELETE /topics/:topic/messages/:id              删除消息
+//	POST   /topics/:topic/messages/:id/requeue      重新投递消息
+//	POST   /topics/:topic/pause                     暂停主题
+//	POST   /topics/:topic/unpause                   恢复主题
+func RegisterRoutes(router gin.IRouter, inspector queue.Inspector) {
+	h := &handler{inspector: inspector}
+	router.GET("/topics", restkit.AdaptToGinHandler(restkit.HandlerFunc[[]queue.TopicInfo](h.topics)))
+	router.GET("/topics/:topic/messages", restkit.AdaptToGinHandler(restkit.HandlerFunc[[]queue.MessageInfo](h.messages)))
+	router.DELETE("/topics/:topic/messages/:id", restkit.AdaptToGinHandler(restkit.HandlerFunc[any](h.deleteMessage)))
+	router.POST("/topics/:topic/messages/:id/requeue", restkit.AdaptToGinHandler(restkit.HandlerFunc[any](h.requeueMessage)))
+	router.POST("/topics/:topic/pause", restkit.AdaptToGinHandler(restkit.HandlerFunc[any](h.pauseTopic)))
+	router.POST("/topics/:topic/unpause", restkit.AdaptToGinHandler(restkit.HandlerFunc[any](h.unpauseTopic)))
+}
+
+func (h *handler) topics(ctx *gin.Context) ([]queue.TopicInfo, error) {
+	return h.inspector.Topics(ctx)
+}
+
+func (h *handler) messages(ctx *gin.Context) ([]queue.MessageInfo, error) {
+	var query MessagesQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		return nil, err
+	}
+	if query.PageSize == 0 {
+		query.PageSize = defaultPageSize
+	}
+	return h.inspector.Messages(ctx, ctx.Param("topic"), query.State, query.Page, query.PageSize)
+}
+
+func (h *handler) deleteMessage(ctx *gin.Context) (any, error) {
+	return nil, badRequest(h.inspector.DeleteMessage(ctx, ctx.Param("topic"), ctx.Param("id")))
+}
+
+func (h *handler) requeueMessage(ctx *gin.Context) (any, error) {
+	return nil, badRequest(h.inspector.RequeueMessage(ctx, ctx.Param("topic"), ctx.Param("id")))
+}
+
+func (h *handler) pauseTopic(ctx *gin.Context) (any, error) {
+	return nil, h.inspector.PauseTopic(ctx, ctx.Param("topic"))
+}
+
+func (h *handler) unpauseTopic(ctx *gin.Context) (any, error) {
+	return nil, h.inspector.UnpauseTopic(ctx, ctx.Param("topic"))
+}
+
+// badRequest 将消息不存在转换为 bad request 响应
+func badRequest(err error) error {
+	if errors.Is(err, queue.ErrMessageNotFound) {
+		return commonErr.ServiceError{Code: constant.ErrorBadRequest.Code, Message: err.Error()}
+	}
+	return err
+}
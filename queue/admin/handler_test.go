@@ -0,0 +1,66 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bmizerany/assert"
+	"github.com/gin-gonic/gin"
+
+	"github.com/chaihaobo/gocommon/logger"
+	"github.com/chaihaobo/gocommon/queue"
+)
+
+type response[T any] struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Data    T      `json:"data"`
+}
+
+func serve[T any](t *testing.T, router *gin.Engine, method, path string) (int, response[T]) {
+	t.Helper()
+	request, _ := http.NewRequest(method, path, nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	var body response[T]
+	assert.Equal(t, nil, json.Unmarshal(recorder.Body.Bytes(), &body))
+	return recorder.Code, body
+}
+
+func TestRegisterRoutes(t *testing.T) {
+	memoryQueue := queue.NewMemoryQueue(logger.NewNoopLogger(), 1)
+	defer memoryQueue.Shutdown(context.Background())
+	inspector := memoryQueue.(queue.Inspector)
+	router := gin.New()
+	RegisterRoutes(router, inspector)
+
+	ctx := context.Background()
+	assert.Equal(t, nil, memoryQueue.Publish(ctx, "order", []byte("foo"), queue.WithMessageID("order-1")))
+	assert.Equal(t, nil, memoryQueue.Publish(ctx, "order", []byte("bar"), queue.WithMessageID("order-2")))
+
+	code, pause := serve[any](t, router, http.MethodPost, "/topics/order/pause")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "0000000", pause.Code)
+
+	_, topics := serve[[]queue.TopicInfo](t, router, http.MethodGet, "/topics")
+	assert.Equal(t, []queue.TopicInfo{{Topic: "order", Pending: 2, Paused: true}}, topics.Data)
+
+	_, messages := serve[[]queue.MessageInfo](t, router, http.MethodGet, "/topics/order/messages?state=pending&page=2&page_size=1")
+	assert.Equal(t, 1, len(messages.Data))
+	assert.Equal(t, "order-2", messages.Data[0].MessageID)
+	assert.Equal(t, "bar", string(messages.Data[0].Payload))
+
+	code, _ = serve[any](t, router, http.MethodGet, "/topics/order/messages?state=unknown")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = serve[any](t, router, http.MethodDelete, "/topics/order/messages/order-1")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = serve[any](t, router, http.MethodDelete, "/topics/order/messages/order-1")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	_, topics = serve[[]queue.TopicInfo](t, router, http.MethodGet, "/topics")
+	assert.Equal(t, 1, topics.Data[0].Pending)
+}
@@ -0,0 +1,190 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const defaultBatchPublishConcurrency = 16
+
+type (
+	// PublishResult 批量发布时单条消息的发布结果
+	PublishResult struct {
+		// MessageID 发布成功的消息ID
+		MessageID string
+		// Err 发布失败的错误
+		Err error
+	}
+
+	// BatchSubscriber 批量订阅者. 一次处理多条消息, 适用于批量写入数据库等场景
+	BatchSubscriber interface {
+		SubscribeBatch(ctx context.Context, topic string, messages [][]byte) error
+	}
+
+	BatchSubscriberFunc func(ctx context.Context, topic string, messages [][]byte) error
+
+	// batcher 将单条投递的消息攒批后交给批量订阅者处理
+	batcher struct {
+		subscriber BatchSubscriber
+		size       int
+		wait       time.Duration
+
+		mu      sync.Mutex
+		batches map[string]*batch
+	}
+
+	batch struct {
+		// ctx 批量订阅者处理批次的 context. 批次内的消息超时或者停止订阅时取消
+		ctx     context.Context
+		cancel  context.CancelFunc
+		items   [][]byte
+		results []chan error
+		timer   *time.Timer
+	}
+)
+
+func (b BatchSubscriberFunc) SubscribeBatch(ctx context.Context, topic string, messages [][]byte) error {
+	return b(ctx, topic, messages)
+}
+
+// NewBatchSubscriber 将批量订阅者适配为订阅者. 消息攒满 size 条或者第一条消息等待超过 wait 时交给批量订阅者处理.
+// 批量订阅者的处理结果会作为批次内每条消息的处理结果, 失败时整个批次的消息都会按照重试策略重试.
+// 每条消息在批次处理完成之前会占用一个处理协程, 因此队列的并发数需要不小于 size 才能攒满批次
+func NewBatchSubscriber(subscriber BatchSubscriber, size int, wait time.Duration) Subscriber {
+	if size <= 0 {
+		size = 1
+	}
+	b := &batcher{
+		subscriber: subscriber,
+		size:       size,
+		wait:       wait,
+		batches:    make(map[string]*batch),
+	}
+	return SubscriberFunc(b.add)
+}
+
+// CreateBatchSubscriber 通过处理函数返回批量订阅者. 消息使用注册订阅者时指定的 Codec 解码
+func CreateBatchSubscriber[T any](handleFunc func(ctx context.Context, topic string, messages []T) error) BatchSubscriber {
+	return BatchSubscriberFunc(func(ctx context.Context, topic string, payloads [][]byte) error {
+		codec := CodecFromContext(ctx)
+		messages := make([]T, 0, len(payloads))
+		for _, payload := range payloads {
+			message, err := decodeMessage[T](codec, payload)
+			if err != nil {
+				return err
+			}
+			messages = append(messages, message)
+		}
+		return handleFunc(ctx, topic, messages)
+	})
+}
+
+func (b *batcher) add(ctx context.Context, topic string, message []byte) error {
+	result := make(chan error, 1)
+	b.mu.Lock()
+	current, ok := b.batches[topic]
+	if !ok {
+		current = &batch{}
+		current.ctx, current.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		b.batches[topic] = current
+		if b.size > 1 {
+			current.timer = time.AfterFunc(b.wait, func() {
+				b.flush(topic, current)
+			})
+		}
+	}
+	current.items = append(current.items, message)
+	current.results = append(current.results, result)
+	full := len(current.items) >= b.size
+	b.mu.Unlock()
+
+	if full {
+		b.flush(topic, current)
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+	}
+	if b.remove(topic, current, result) {
+		return ctx.Err()
+	}
+	// 批次已经开始处理, 取消批次并且等待处理结果, 避免消息在批次中处理成功之后又被重试
+	current.cancel()
+	return <-result
+}
+
+// remove 从尚未处理的批次中移除消息. 批次已经开始处理时返回 false
+func (b *batcher) remove(topic string, current *batch, result chan error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.batches[topic] != current {
+		return false
+	}
+	for i := range current.results {
+		if current.results[i] == result {
+			current.items = append(current.items[:i], current.items[i+1:]...)
+			current.results = append(current.results[:i], current.results[i+1:]...)
+			break
+		}
+	}
+	if len(current.items) == 0 {
+		delete(b.batches, topic)
+		if current.timer != nil {
+			current.timer.Stop()
+		}
+		current.cancel()
+	}
+	return true
+}
+
+// flush 处理批次. 同一个批次只会被处理一次
+func (b *batcher) flush(topic string, current *batch) {
+	b.mu.Lock()
+	if b.batches[topic] != current {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.batches, topic)
+	if current.timer != nil {
+		current.timer.Stop()
+	}
+	b.mu.Unlock()
+
+	err := b.subscriber.SubscribeBatch(current.ctx, topic, current.items)
+	current.cancel()
+	for _, result := range current.results {
+		result <- err
+	}
+}
+
+// publishBatch 使用 concurrency 个 goroutine 发布消息, 收集每条消息的发布结果
+func publishBatch(ctx context.Context, messages []any, concurrency int,
+	publish func(ctx context.Context, message any) (string, error)) ([]PublishResult, error) {
+	results := make([]PublishResult, len(messages))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, message := range messages {
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(i int, message any) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			messageID, err := publish(ctx, message)
+			results[i] = PublishResult{MessageID: messageID, Err: err}
+		}(i, message)
+	}
+	wg.Wait()
+
+	errs := make([]error, 0)
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+	return results, errors.Join(errs...)
+}
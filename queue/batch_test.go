@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+func TestMemoryQueue_PublishBatch(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 4)
+	batches := make(chan []int64, 2)
+	queue.SubscribeTo("batch", NewBatchSubscriber(CreateBatchSubscriber(func(ctx context.Context, topic string, messages []*orderCreated) error {
+		ids := make([]int64, 0, len(messages))
+		for _, message := range messages {
+			ids = append(ids, message.ID)
+		}
+		batches <- ids
+		return nil
+	}), 3, 100*time.Millisecond), WithCodec(JSONCodec))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	results, err := queue.PublishBatch(context.Background(), "batch", []any{
+		&orderCreated{ID: 1}, &orderCreated{ID: 2}, &orderCreated{ID: 3}, &orderCreated{ID: 4},
+	}, WithCodec(JSONCodec))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(results))
+	for _, result := range results {
+		assert.Equal(t, nil, result.Err)
+		assert.NotEqual(t, "", result.MessageID)
+	}
+	assert.Equal(t, 3, len(waitMessage(t, batches)))
+	assert.Equal(t, 1, len(waitMessage(t, batches)))
+
+	results, err = queue.PublishBatch(context.Background(), "batch", []any{[]byte("foo"), "unsupported"})
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, nil, results[0].Err)
+	assert.NotEqual(t, nil, results[1].Err)
+}
+
+func TestBatchSubscriber_Cancelled(t *testing.T) {
+	batches := make(chan []string, 2)
+	subscriber := NewBatchSubscriber(BatchSubscriberFunc(func(ctx context.Context, topic string, messages [][]byte) error {
+		items := make([]string, 0, len(messages))
+		for _, message := range messages {
+			items = append(items, string(message))
+		}
+		batches <- items
+		if items[0] == "block" {
+			// 停止订阅时取消正在处理的批次
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}), 2, 50*time.Millisecond)
+
+	// 等待攒批时超时的消息从批次中移除, 不会再被处理
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, subscriber.Subscribe(ctx, "batch", []byte("cancelled")))
+	assert.Equal(t, nil, subscriber.Subscribe(context.Background(), "batch", []byte("foo")))
+	assert.Equal(t, []string{"foo"}, waitMessage(t, batches))
+
+	// 批次开始处理之后取消, 消息的处理结果与批次一致
+	ctx, cancel = context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- subscriber.Subscribe(ctx, "batch", []byte("block"))
+	}()
+	assert.Equal(t, []string{"block"}, waitMessage(t, batches))
+	cancel()
+	assert.Equal(t, context.Canceled, waitMessage(t, result))
+}
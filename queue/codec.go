@@ -0,0 +1,104 @@
+package queue
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// BinaryCodec 默认的编解码器. 消息必须为[]byte 或者 实现了encoding.BinaryMarshaler/encoding.BinaryUnmarshaler接口
+	BinaryCodec Codec = binaryCodec{}
+	// JSONCodec 使用 encoding/json 编解码消息
+	JSONCodec Codec = jsonCodec{}
+	// ProtoCodec 使用 protobuf 编解码消息. 消息必须实现 proto.Message 接口
+	ProtoCodec Codec = protoCodec{}
+)
+
+type (
+	// Codec 消息编解码器. 发布时将消息编码为字节, 订阅时将字节解码为消息
+	Codec interface {
+		Marshal(message any) ([]byte, error)
+		Unmarshal(data []byte, message any) error
+	}
+
+	binaryCodec struct{}
+	jsonCodec   struct{}
+	protoCodec  struct{}
+
+	codecContextKey struct{}
+)
+
+func (binaryCodec) Marshal(message any) ([]byte, error) {
+	switch result := message.(type) {
+	case []byte:
+		return result, nil
+	case encoding.BinaryMarshaler:
+		return result.MarshalBinary()
+	default:
+		return nil, fmt.Errorf("unsupported message type: %T, only support []byte|encoding.BinaryMarshaler", message)
+	}
+}
+
+func (binaryCodec) Unmarshal(data []byte, message any) error {
+	switch result := message.(type) {
+	case *[]byte:
+		*result = data
+		return nil
+	case encoding.BinaryUnmarshaler:
+		return result.UnmarshalBinary(data)
+	default:
+		return fmt.Errorf("unsupported message type: %T, only support []byte|encoding.BinaryUnmarshaler", message)
+	}
+}
+
+func (jsonCodec) Marshal(message any) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (jsonCodec) Unmarshal(data []byte, message any) error {
+	return json.Unmarshal(data, message)
+}
+
+func (protoCodec) Marshal(message any) ([]byte, error) {
+	protoMessage, ok := message.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("unsupported message type: %T, only support proto.Message", message)
+	}
+	return proto.Marshal(protoMessage)
+}
+
+func (protoCodec) Unmarshal(data []byte, message any) error {
+	protoMessage, ok := message.(proto.Message)
+	if !ok {
+		return fmt.Errorf("unsupported message type: %T, only support proto.Message", message)
+	}
+	return proto.Unmarshal(data, protoMessage)
+}
+
+// CodecFromContext 返回订阅者注册时指定的编解码器. 未指定时返回 BinaryCodec
+func CodecFromContext(ctx context.Context) Codec {
+	if codec, ok := ctx.Value(codecContextKey{}).(Codec); ok {
+		return codec
+	}
+	return BinaryCodec
+}
+
+func contextWithCodec(ctx context.Context, codec Codec) context.Context {
+	return context.WithValue(ctx, codecContextKey{}, codec)
+}
+
+// decodeMessage 使用 codec 将 payload 解码为 T. T 为指针类型时会自动分配内存
+func decodeMessage[T any](codec Codec, payload []byte) (T, error) {
+	var message T
+	messageType := reflect.TypeOf((*T)(nil)).Elem()
+	if messageType.Kind() == reflect.Ptr {
+		message = reflect.New(messageType.Elem()).Interface().(T)
+		return message, codec.Unmarshal(payload, message)
+	}
+	return message, codec.Unmarshal(payload, &message)
+}
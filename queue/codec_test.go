@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bmizerany/assert"
+
+	commonErr "github.com/chaihaobo/gocommon/error"
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+type orderCreated struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+}
+
+func TestDecodeMessage(t *testing.T) {
+	bytes, err := decodeMessage[[]byte](BinaryCodec, []byte("foo"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "foo", string(bytes))
+
+	binary, err := decodeMessage[*testMessage](BinaryCodec, []byte("bar"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "bar", binary.Content)
+
+	_, err = decodeMessage[string](BinaryCodec, []byte("baz"))
+	assert.NotEqual(t, nil, err)
+
+	value, err := decodeMessage[orderCreated](JSONCodec, []byte(`{"id":1,"status":"paid"}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, orderCreated{ID: 1, Status: "paid"}, value)
+
+	pointer, err := decodeMessage[*orderCreated](JSONCodec, []byte(`{"id":2}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(2), pointer.ID)
+}
+
+func TestPublisher_Codec(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1, WithCodec(JSONCodec))
+	orders := make(chan orderCreated, 1)
+	queue.SubscribeTo("order.created", CreateSubscriber(func(ctx context.Context, topic string, message orderCreated) error {
+		orders <- message
+		return nil
+	}))
+	errs := make(chan *commonErr.Error, 1)
+	queue.SubscribeTo("error.raised", CreateSubscriber(func(ctx context.Context, topic string, message *commonErr.Error) error {
+		errs <- message
+		return nil
+	}), WithCodec(ProtoCodec))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	ctx := context.Background()
+	orderPublisher := NewPublisher[orderCreated](queue, "order.created")
+	assert.Equal(t, nil, orderPublisher.Publish(ctx, orderCreated{ID: 1, Status: "created"}))
+	assert.Equal(t, orderCreated{ID: 1, Status: "created"}, waitMessage(t, orders))
+
+	errPublisher := NewPublisher[*commonErr.Error](queue, "error.raised", WithCodec(ProtoCodec))
+	assert.Equal(t, nil, errPublisher.Publish(ctx, &commonErr.Error{Code: "0000001", Message: "bad request"}))
+	received := waitMessage(t, errs)
+	assert.Equal(t, "0000001", received.Code)
+	assert.Equal(t, "bad request", received.Message)
+}
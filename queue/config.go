@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/hibiken/asynq"
+)
+
+// DefaultShutdownTimeout 默认的停止订阅等待时间
+const DefaultShutdownTimeout = 8 * time.Second
+
+const (
+	// PriorityCritical 高优先级队列
+	PriorityCritical Priority = "critical"
+	// PriorityDefault 默认优先级队列. 未指定优先级以及队列名称的消息投递到该队列
+	PriorityDefault Priority = "default"
+	// PriorityLow 低优先级队列
+	PriorityLow Priority = "low"
+)
+
+var (
+	// DefaultQueues 默认的队列以及权重. 对应 PriorityCritical, PriorityDefault, PriorityLow
+	DefaultQueues = map[string]int{
+		string(PriorityCritical): 6,
+		string(PriorityDefault):  3,
+		string(PriorityLow):      1,
+	}
+)
+
+type (
+	// Priority 消息的优先级. 对应 redis 队列中的队列名称
+	Priority string
+
+	// RedisQueueConfig redis 队列的配置
+	RedisQueueConfig struct {
+		Address  string
+		DB       int
+		Password string
+		// Concurrency 同时处理消息的 goroutine 数量. 不设置时使用 CPU 核数
+		Concurrency int
+		// Queues 订阅的队列名称以及权重. 权重越高的队列被处理的机会越大
+		// 不设置时使用 DefaultQueues. 发布到未订阅的队列中的消息不会被处理
+		Queues map[string]int
+		// StrictPriority 严格优先级. 开启后只有权重高的队列为空时才处理权重低的队列
+		StrictPriority bool
+		// ShutdownTimeout RunSubscriber 收到退出信号后等待正在处理的消息完成的时间. 不设置时为 DefaultShutdownTimeout
+		ShutdownTimeout time.Duration
+		// DelayedTaskCheckInterval 检查延迟消息以及重试消息的间隔. 等待顺序键中更早的消息完成的消息在该间隔内再次投递.
+		// 不设置时使用 asynq 的默认值 5 秒, 使用 WithOrderingKey 的服务可以适当调小
+		DelayedTaskCheckInterval time.Duration
+	}
+)
+
+func (c RedisQueueConfig) redisClientOpt() asynq.RedisClientOpt {
+	return asynq.RedisClientOpt{
+		Addr:     c.Address,
+		DB:       c.DB,
+		Password: c.Password,
+	}
+}
+
+// getQueues 返回订阅的队列名称以及权重. 不设置时为 DefaultQueues
+func (c RedisQueueConfig) getQueues() map[string]int {
+	if len(c.Queues) == 0 {
+		return DefaultQueues
+	}
+	return c.Queues
+}
+
+func (c RedisQueueConfig) getShutdownTimeout() time.Duration {
+	if c.ShutdownTimeout <= 0 {
+		return DefaultShutdownTimeout
+	}
+	return c.ShutdownTimeout
+}
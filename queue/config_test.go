@@ -0,0 +1,36 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestRedisQueueConfig_AsynqConfig(t *testing.T) {
+	queue := &RedisQueue{shutdownTimeout: RedisQueueConfig{}.getShutdownTimeout(), baseCtx: context.Background()}
+	config := queue.asynqConfig(RedisQueueConfig{})
+	assert.Equal(t, DefaultQueues, config.Queues)
+	assert.Equal(t, false, config.StrictPriority)
+	assert.Equal(t, DefaultShutdownTimeout, config.ShutdownTimeout)
+	assert.Equal(t, queue.baseCtx, config.BaseContext())
+
+	queues := map[string]int{"critical": 10, "default": 1}
+	queue.shutdownTimeout = RedisQueueConfig{ShutdownTimeout: time.Minute}.getShutdownTimeout()
+	config = queue.asynqConfig(RedisQueueConfig{
+		Concurrency:              4,
+		Queues:                   queues,
+		StrictPriority:           true,
+		DelayedTaskCheckInterval: time.Second,
+	})
+	assert.Equal(t, 4, config.Concurrency)
+	assert.Equal(t, queues, config.Queues)
+	assert.Equal(t, true, config.StrictPriority)
+	assert.Equal(t, time.Minute, config.ShutdownTimeout)
+	assert.Equal(t, time.Second, config.DelayedTaskCheckInterval)
+	// 等待顺序键以及达到并发限制的消息不计入重试次数
+	assert.Equal(t, false, config.IsFailure(errOrderingWait))
+	assert.Equal(t, false, config.IsFailure(errConcurrencyLimited))
+	assert.Equal(t, true, config.IsFailure(context.DeadlineExceeded))
+}
@@ -0,0 +1,170 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DedupAcquired 占用成功, 消息可以被处理
+	DedupAcquired DedupStatus = iota
+	// DedupProcessing 消息正在被其他订阅者处理
+	DedupProcessing
+	// DedupCompleted 消息已经被成功处理
+	DedupCompleted
+)
+
+var (
+	ErrMessageProcessing = errors.New("message is being processed")
+	ErrDuplicateMessage  = errors.New("duplicate message")
+	// ErrInvalidUniqueWindow WithUnique 的 window 不大于 0
+	ErrInvalidUniqueWindow = errors.New("unique window must be positive")
+)
+
+const (
+	// uniqueKeyPrefix WithUnique 的 key 在 redis 中的前缀
+	uniqueKeyPrefix = "queue:unique:"
+	// memoryDedupSweepInterval 内存去重存储清理过期记录的间隔
+	memoryDedupSweepInterval = time.Minute
+)
+
+type (
+	// DedupStatus 消息的去重状态
+	DedupStatus int
+
+	// DedupStore 消息去重的存储. 记录消息ID的处理状态
+	DedupStore interface {
+		// Acquire 尝试占用消息ID. 消息ID不存在时占用成功并返回 DedupAcquired, 占用在 ttl 后自动失效
+		// 否则返回消息当前的处理状态
+		Acquire(ctx context.Context, id string, ttl time.Duration) (DedupStatus, error)
+		// Complete 标记消息已经处理成功. 记录在 ttl 后失效
+		Complete(ctx context.Context, id string, ttl time.Duration) error
+		// Release 释放消息ID的占用. 处理失败的消息重试时可以再次被处理
+		Release(ctx context.Context, id string) error
+	}
+
+	memoryDedupStore struct {
+		mu      sync.Mutex
+		entries map[string]memoryDedupEntry
+		// sweptAt 上一次清理过期记录的时间
+		sweptAt time.Time
+	}
+
+	memoryDedupEntry struct {
+		status   DedupStatus
+		expireAt time.Time
+	}
+)
+
+func (m *memoryDedupStore) Acquire(ctx context.Context, id string, ttl time.Duration) (DedupStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.sweep(now)
+	if entry, ok := m.entries[id]; ok && now.Before(entry.expireAt) {
+		return entry.status, nil
+	}
+	m.entries[id] = memoryDedupEntry{
+		status:   DedupProcessing,
+		expireAt: now.Add(ttl),
+	}
+	return DedupAcquired, nil
+}
+
+// sweep 每隔 memoryDedupSweepInterval 删除过期的记录, 避免长时间运行的进程内存不断增长. 调用方需要持有锁
+func (m *memoryDedupStore) sweep(now time.Time) {
+	if now.Sub(m.sweptAt) < memoryDedupSweepInterval {
+		return
+	}
+	m.sweptAt = now
+	for id, entry := range m.entries {
+		if !now.Before(entry.expireAt) {
+			delete(m.entries, id)
+		}
+	}
+}
+
+func (m *memoryDedupStore) Complete(ctx context.Context, id string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[id] = memoryDedupEntry{
+		status:   DedupCompleted,
+		expireAt: time.Now().Add(ttl),
+	}
+	return nil
+}
+
+func (m *memoryDedupStore) Release(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, id)
+	return nil
+}
+
+// acquireUnique 占用 WithUnique 指定的 key, 已经被占用时返回 ErrDuplicateMessage. 返回的函数用于发布失败时释放 key.
+// window 不大于 0 时返回 ErrInvalidUniqueWindow, 避免 redis 中的 key 永不过期
+func acquireUnique(ctx context.Context, store DedupStore, topic string, options *options) (func(), error) {
+	if options.uniqueKey == "" {
+		return func() {}, nil
+	}
+	if options.uniqueWindow <= 0 {
+		return nil, ErrInvalidUniqueWindow
+	}
+	id := topic + ":" + options.uniqueKey
+	status, err := store.Acquire(ctx, id, options.uniqueWindow)
+	if err != nil {
+		return nil, err
+	}
+	if status != DedupAcquired {
+		return nil, ErrDuplicateMessage
+	}
+	return func() {
+		_ = store.Release(context.WithoutCancel(ctx), id)
+	}, nil
+}
+
+// NewMemoryDedupStore 创建基于内存的去重存储. 仅在当前进程内生效, 适用于单元测试以及单进程服务
+func NewMemoryDedupStore() DedupStore {
+	return &memoryDedupStore{
+		entries: make(map[string]memoryDedupEntry),
+	}
+}
+
+// IdempotentMiddleware 幂等消费中间件. 已经处理成功的消息ID再次投递时直接确认, 不会再次调用订阅者.
+// processingTTL 为处理中的占用时间, 应当大于订阅者处理一条消息的最长耗时; completedTTL 为处理成功的记录保留时间.
+// 消息正在被其他订阅者处理时返回 ErrMessageProcessing, 消息会按照重试策略稍后重试.
+// 去重的 key 包含订阅者的标识, 同一个主题的不同消费组共享存储时各自处理消息的副本
+func IdempotentMiddleware(store DedupStore, processingTTL, completedTTL time.Duration) SubscriberMiddleware {
+	return func(next Subscriber) Subscriber {
+		return SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+			messageID := MessageIDFromContext(ctx)
+			if messageID == "" {
+				return next.Subscribe(ctx, topic, message)
+			}
+			key := topic + ":" + messageID
+			if subscription := subscriptionFromContext(ctx); subscription != "" {
+				key = subscription + ":" + key
+			}
+			status, err := store.Acquire(ctx, key, processingTTL)
+			if err != nil {
+				return err
+			}
+			switch status {
+			case DedupCompleted:
+				return nil
+			case DedupProcessing:
+				return ErrMessageProcessing
+			}
+			// 处理超时或者被取消时 ctx 已经失效, 记录处理结果时不使用 ctx 的取消
+			if err := next.Subscribe(ctx, topic, message); err != nil {
+				if releaseErr := store.Release(context.WithoutCancel(ctx), key); releaseErr != nil {
+					return errors.Join(err, releaseErr)
+				}
+				return err
+			}
+			return store.Complete(context.WithoutCancel(ctx), key, completedTTL)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRedisDedupPrefix = "queue:dedup:"
+
+	redisDedupProcessing = "processing"
+	redisDedupCompleted  = "completed"
+)
+
+type redisDedupStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func (r *redisDedupStore) Acquire(ctx context.Context, id string, ttl time.Duration) (DedupStatus, error) {
+	key := r.prefix + id
+	acquired, err := r.client.SetNX(ctx, key, redisDedupProcessing, ttl).Result()
+	if err != nil {
+		return DedupProcessing, err
+	}
+	if acquired {
+		return DedupAcquired, nil
+	}
+	status, err := r.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		// 占用恰好过期, 按处理中返回等待重试
+		return DedupProcessing, nil
+	}
+	if err != nil {
+		return DedupProcessing, err
+	}
+	if status == redisDedupCompleted {
+		return DedupCompleted, nil
+	}
+	return DedupProcessing, nil
+}
+
+func (r *redisDedupStore) Complete(ctx context.Context, id string, ttl time.Duration) error {
+	return r.client.Set(ctx, r.prefix+id, redisDedupCompleted, ttl).Err()
+}
+
+func (r *redisDedupStore) Release(ctx context.Context, id string) error {
+	return r.client.Del(ctx, r.prefix+id).Err()
+}
+
+// NewRedisDedupStore 创建基于 redis 的去重存储. 多个副本之间共享消息的处理状态
+// prefix 为 redis key 的前缀, 为空时使用 queue:dedup:
+func NewRedisDedupStore(client redis.UniversalClient, prefix string) DedupStore {
+	if prefix == "" {
+		prefix = defaultRedisDedupPrefix
+	}
+	return &redisDedupStore{
+		client: client,
+		prefix: prefix,
+	}
+}
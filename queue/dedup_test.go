@@ -0,0 +1,137 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bmizerany/assert"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+func TestIdempotentMiddleware(t *testing.T) {
+	store := NewMemoryDedupStore()
+	calls := 0
+	failed := true
+	subscriber := IdempotentMiddleware(store, time.Minute, time.Hour)(SubscriberFunc(
+		func(ctx context.Context, topic string, message []byte) error {
+			calls++
+			if failed {
+				return errors.New("boom")
+			}
+			return nil
+		}))
+	ctx := contextWithMessageID(context.Background(), "message-1")
+
+	assert.NotEqual(t, nil, subscriber.Subscribe(ctx, "payment", nil))
+	failed = false
+	assert.Equal(t, nil, subscriber.Subscribe(ctx, "payment", nil))
+	assert.Equal(t, nil, subscriber.Subscribe(ctx, "payment", nil))
+	assert.Equal(t, 2, calls)
+
+	status, err := store.Acquire(context.Background(), "payment:message-2", time.Minute)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, DedupAcquired, status)
+	assert.Equal(t, ErrMessageProcessing, subscriber.Subscribe(contextWithMessageID(context.Background(), "message-2"), "payment", nil))
+}
+
+func TestMemoryQueue_WithMessageID(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1,
+		WithSubscriberMiddlewares(IdempotentMiddleware(NewMemoryDedupStore(), time.Minute, time.Hour)))
+	received := make(chan string, 3)
+	queue.SubscribeTo("payment", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		received <- MessageIDFromContext(ctx)
+		return nil
+	}))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	ctx := context.Background()
+	assert.Equal(t, nil, queue.Publish(ctx, "payment", []byte("foo"), WithMessageID("payment-1")))
+	assert.Equal(t, nil, queue.Publish(ctx, "payment", []byte("foo"), WithMessageID("payment-1")))
+	assert.Equal(t, nil, queue.Publish(ctx, "payment", []byte("bar")))
+	assert.Equal(t, "payment-1", waitMessage(t, received))
+	assert.NotEqual(t, "", waitMessage(t, received))
+	select {
+	case id := <-received:
+		t.Fatalf("duplicated message %s consumed", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestIdempotentMiddleware_FanOut(t *testing.T) {
+	store := NewMemoryDedupStore()
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 2,
+		WithSubscriberMiddlewares(IdempotentMiddleware(store, time.Minute, time.Hour)))
+	received := make(chan string, 2)
+	subscriber := func(name string) Subscriber {
+		return SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+			received <- name
+			return nil
+		})
+	}
+	queue.SubscribeTo("order.paid", subscriber("billing"), WithGroup("billing"))
+	queue.SubscribeTo("order.paid", subscriber("shipping"), WithGroup("shipping"))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	assert.Equal(t, nil, queue.Publish(context.Background(), "order.paid", []byte("foo"), WithMessageID("order-1")))
+	messages := []string{waitMessage(t, received), waitMessage(t, received)}
+	sort.Strings(messages)
+	assert.Equal(t, []string{"billing", "shipping"}, messages)
+}
+
+func TestMemoryDedupStore_Sweep(t *testing.T) {
+	store := NewMemoryDedupStore().(*memoryDedupStore)
+	ctx := context.Background()
+	_, err := store.Acquire(ctx, "expired", time.Nanosecond)
+	assert.Equal(t, nil, err)
+	_, err = store.Acquire(ctx, "alive", time.Hour)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(store.entries))
+
+	store.sweptAt = time.Now().Add(-memoryDedupSweepInterval)
+	status, err := store.Acquire(ctx, "alive", time.Hour)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, DedupProcessing, status)
+	assert.Equal(t, 1, len(store.entries))
+}
+
+func TestIdempotentMiddleware_Cancelled(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	store := NewRedisDedupStore(client, "")
+	calls := 0
+	failed := true
+	subscriber := IdempotentMiddleware(store, time.Minute, time.Hour)(SubscriberFunc(
+		func(ctx context.Context, topic string, message []byte) error {
+			calls++
+			// 模拟处理期间超时
+			ctx.Value(cancelContextKey{}).(context.CancelFunc)()
+			if failed {
+				return ctx.Err()
+			}
+			return nil
+		}))
+	subscribe := func() error {
+		ctx, cancel := context.WithCancel(contextWithMessageID(context.Background(), "message-1"))
+		defer cancel()
+		return subscriber.Subscribe(context.WithValue(ctx, cancelContextKey{}, cancel), "payment", nil)
+	}
+
+	// ctx 被取消之后仍然释放处理中的状态, 重试时可以再次处理
+	assert.Equal(t, context.Canceled, subscribe())
+	failed = false
+	// ctx 被取消之后仍然记录处理完成, 重复投递的消息不会再次处理
+	assert.Equal(t, nil, subscribe())
+	assert.Equal(t, nil, subscribe())
+	assert.Equal(t, 2, calls)
+}
+
+type cancelContextKey struct{}
@@ -0,0 +1,143 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const envelopeVersion = 1
+
+var _ propagation.TextMapCarrier = Metadata(nil)
+
+type (
+	// Metadata 消息的元数据. 与消息一起投递, 用于携带链路信息以及业务自定义的头信息
+	Metadata map[string]string
+
+	// envelope 消息在队列中的存储格式. 包含元数据以及编码后的消息
+	envelope struct {
+		Version  int      `json:"v"`
+		ID       string   `json:"id,omitempty"`
+		Metadata Metadata `json:"metadata,omitempty"`
+		Payload  []byte   `json:"payload"`
+		// AvailableAt 消息可被消费的时间. 用于计算消费延迟
+		AvailableAt time.Time `json:"available_at,omitempty"`
+		// ExpiresAt 消息过期的时间. 过期的消息不再投递给订阅者
+		ExpiresAt time.Time `json:"expires_at,omitempty"`
+		// Deadline 订阅者处理消息的截止时间
+		Deadline time.Time `json:"deadline,omitempty"`
+		// Timeout 订阅者每次处理消息的超时时间
+		Timeout time.Duration `json:"timeout,omitempty"`
+		// OrderingKey 消息的顺序键
+		OrderingKey string `json:"ordering_key,omitempty"`
+		// Sequence 消息在顺序键中的序号. 仅 redis 队列使用
+		Sequence int64 `json:"sequence,omitempty"`
+	}
+
+	metadataContextKey     struct{}
+	messageIDContextKey    struct{}
+	subscriptionContextKey struct{}
+)
+
+func (m Metadata) Get(key string) string {
+	return m[key]
+}
+
+func (m Metadata) Set(key string, value string) {
+	m[key] = value
+}
+
+func (m Metadata) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (m Metadata) clone() Metadata {
+	result := make(Metadata, len(m))
+	for key, value := range m {
+		result[key] = value
+	}
+	return result
+}
+
+// MetadataFromContext 返回订阅者收到的消息的元数据
+func MetadataFromContext(ctx context.Context) Metadata {
+	if metadata, ok := ctx.Value(metadataContextKey{}).(Metadata); ok {
+		return metadata
+	}
+	return Metadata{}
+}
+
+func contextWithMetadata(ctx context.Context, metadata Metadata) context.Context {
+	return context.WithValue(ctx, metadataContextKey{}, metadata)
+}
+
+// MessageIDFromContext 返回订阅者收到的消息的ID. 未携带消息ID的消息返回空字符串
+func MessageIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(messageIDContextKey{}).(string)
+	return id
+}
+
+func contextWithMessageID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, messageIDContextKey{}, id)
+}
+
+// subscriptionFromContext 返回处理消息的订阅者的标识
+func subscriptionFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(subscriptionContextKey{}).(string)
+	return key
+}
+
+func contextWithSubscription(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, subscriptionContextKey{}, key)
+}
+
+func encodeEnvelope(envelope *envelope) ([]byte, error) {
+	envelope.Version = envelopeVersion
+	return json.Marshal(envelope)
+}
+
+// expired 返回消息是否已经过期
+func (e *envelope) expired() bool {
+	return !e.ExpiresAt.IsZero() && time.Now().After(e.ExpiresAt)
+}
+
+// contextWithDeadline 按照消息的截止时间以及超时时间限制订阅者处理消息的时间
+func (e *envelope) contextWithDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	cancels := make([]context.CancelFunc, 0, 2)
+	if !e.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, e.Deadline)
+		cancels = append(cancels, cancel)
+	}
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		cancels = append(cancels, cancel)
+	}
+	return ctx, func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
+
+// decodeEnvelope 解析消息信封. 无法解析时将整个数据作为消息, 兼容未携带信封的消息
+func decodeEnvelope(data []byte) *envelope {
+	result := &envelope{}
+	if err := json.Unmarshal(data, result); err != nil || result.Version != envelopeVersion {
+		return &envelope{
+			Metadata: Metadata{},
+			Payload:  data,
+		}
+	}
+	if result.Metadata == nil {
+		result.Metadata = Metadata{}
+	}
+	return result
+}
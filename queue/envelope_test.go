@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+func TestDecodeEnvelope(t *testing.T) {
+	data, err := encodeEnvelope(&envelope{
+		ID:          "id",
+		Metadata:    Metadata{"key": "value"},
+		Payload:     []byte("foo"),
+		AvailableAt: time.Now(),
+	})
+	assert.Equal(t, nil, err)
+	envelope := decodeEnvelope(data)
+	assert.Equal(t, "foo", string(envelope.Payload))
+	assert.Equal(t, "id", envelope.ID)
+	assert.Equal(t, "value", envelope.Metadata.Get("key"))
+
+	raw := decodeEnvelope([]byte(`{"id":1}`))
+	assert.Equal(t, `{"id":1}`, string(raw.Payload))
+	assert.Equal(t, 0, len(raw.Metadata))
+}
+
+func TestMemoryQueue_TracePropagation(t *testing.T) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	defer otel.SetTracerProvider(oteltrace.NewNoopTracerProvider())
+
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1)
+	type consumed struct {
+		traceID  oteltrace.TraceID
+		metadata Metadata
+	}
+	received := make(chan consumed, 1)
+	queue.SubscribeTo("order", CreateSubscriber(func(ctx context.Context, topic string, message []byte) error {
+		received <- consumed{
+			traceID:  oteltrace.SpanContextFromContext(ctx).TraceID(),
+			metadata: MetadataFromContext(ctx),
+		}
+		return nil
+	}))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "http.request")
+	defer span.End()
+	assert.Equal(t, nil, queue.Publish(ctx, "order", []byte("foo"), WithMetadata("tenant", "acme")))
+	result := waitMessage(t, received)
+	assert.Equal(t, span.SpanContext().TraceID(), result.traceID)
+	assert.Equal(t, "acme", result.metadata.Get("tenant"))
+	assert.NotEqual(t, "", result.metadata.Get("traceparent"))
+}
@@ -0,0 +1,245 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	MessageStatePending   MessageState = "pending"
+	MessageStateActive    MessageState = "active"
+	MessageStateScheduled MessageState = "scheduled"
+	MessageStateRetry     MessageState = "retry"
+	MessageStateDead      MessageState = "dead"
+)
+
+var (
+	ErrMessageNotFound     = errors.New("message not found")
+	ErrInvalidMessageState = errors.New("invalid message state")
+)
+
+var _ Inspector = (*MemoryQueue)(nil)
+
+type (
+	// MessageState 消息在队列中的状态
+	MessageState string
+
+	// TopicInfo 主题以及各个状态的消息数量
+	TopicInfo struct {
+		Topic     string `json:"topic"`
+		Pending   int    `json:"pending"`
+		Active    int    `json:"active"`
+		Scheduled int    `json:"scheduled"`
+		Retry     int    `json:"retry"`
+		Dead      int    `json:"dead"`
+		Paused    bool   `json:"paused"`
+	}
+
+	// MessageInfo 队列中的消息
+	MessageInfo struct {
+		// ID 消息在队列中的ID. 用于删除以及重新入队
+		ID        string       `json:"id"`
+		MessageID string       `json:"message_id"`
+		Topic     string       `json:"topic"`
+		State     MessageState `json:"state"`
+		Payload   []byte       `json:"payload"`
+		Metadata  Metadata     `json:"metadata,omitempty"`
+		Retried   int          `json:"retried"`
+		LastError string       `json:"last_error,omitempty"`
+		// NextProcessAt 下一次投递的时间. 仅延迟消息以及重试消息有值
+		NextProcessAt time.Time `json:"next_process_at,omitempty"`
+	}
+
+	// Inspector 查看以及管理队列中的消息
+	Inspector interface {
+		// Topics 返回所有主题以及各个状态的消息数量. 按照主题排序
+		Topics(ctx context.Context) ([]TopicInfo, error)
+		// Messages 分页返回主题中指定状态的消息. page 从1开始
+		Messages(ctx context.Context, topic string, state MessageState, page, pageSize int) ([]MessageInfo, error)
+		// DeleteMessage 删除主题中的消息. 正在处理的消息不能删除
+		DeleteMessage(ctx context.Context, topic, id string) error
+		// RequeueMessage 将延迟, 重试以及死信中的消息重新入队, 立即投递
+		RequeueMessage(ctx context.Context, topic, id string) error
+		// PauseTopic 暂停投递主题中的消息. 正在处理的消息不受影响
+		PauseTopic(ctx context.Context, topic string) error
+		// UnpauseTopic 恢复投递主题中的消息
+		UnpauseTopic(ctx context.Context, topic string) error
+	}
+)
+
+// Valid 返回消息状态是否合法
+func (s MessageState) Valid() bool {
+	switch s {
+	case MessageStatePending, MessageStateActive, MessageStateScheduled, MessageStateRetry, MessageStateDead:
+		return true
+	}
+	return false
+}
+
+// newMessageInfo 从消息的存储格式中解析消息
+func newMessageInfo(id, topic string, state MessageState, data []byte) MessageInfo {
+	envelope := decodeEnvelope(data)
+	return MessageInfo{
+		ID:        id,
+		MessageID: envelope.ID,
+		Topic:     topic,
+		State:     state,
+		Payload:   envelope.Payload,
+		Metadata:  envelope.Metadata,
+	}
+}
+
+// paginate 返回第 page 页的数据. page 从1开始
+func paginate[T any](items []T, page, pageSize int) []T {
+	if page < 1 {
+		page = 1
+	}
+	start := (page - 1) * pageSize
+	if pageSize <= 0 || start >= len(items) {
+		return []T{}
+	}
+	return items[start:min(start+pageSize, len(items))]
+}
+
+// Topics 返回所有主题以及各个状态的消息数量. 内存队列不保存死信, 死信会投递到死信主题
+func (m *MemoryQueue) Topics(ctx context.Context) ([]TopicInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	topics := make(map[string]*TopicInfo)
+	topicOf := func(topic string) *TopicInfo {
+		info, ok := topics[topic]
+		if !ok {
+			info = &TopicInfo{Topic: topic, Paused: m.paused[topic]}
+			topics[topic] = info
+		}
+		return info
+	}
+	for _, topic := range m.router.topics() {
+		topicOf(topic)
+	}
+	for topic := range m.paused {
+		topicOf(topic)
+	}
+	for _, msg := range m.pending {
+		topicOf(msg.topic).Pending++
+	}
+	for topic, active := range m.active {
+		topicOf(topic).Active += active
+	}
+	for _, msg := range m.timers {
+		if msg.retried > 0 {
+			topicOf(msg.topic).Retry++
+		} else {
+			topicOf(msg.topic).Scheduled++
+		}
+	}
+
+	result := make([]TopicInfo, 0, len(topics))
+	for _, info := range topics {
+		result = append(result, *info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Topic < result[j].Topic
+	})
+	return result, nil
+}
+
+// Messages 分页返回主题中指定状态的消息. 内存队列只支持查看 pending, scheduled 以及 retry 状态的消息
+func (m *MemoryQueue) Messages(ctx context.Context, topic string, state MessageState, page, pageSize int) ([]MessageInfo, error) {
+	if !state.Valid() {
+		return nil, ErrInvalidMessageState
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	messages := make([]MessageInfo, 0)
+	switch state {
+	case MessageStatePending:
+		for _, msg := range m.pending {
+			if msg.topic == topic {
+				messages = append(messages, memoryMessageInfo(msg, state))
+			}
+		}
+	case MessageStateScheduled, MessageStateRetry:
+		for _, msg := range m.timers {
+			if msg.topic == topic && (msg.retried > 0) == (state == MessageStateRetry) {
+				messages = append(messages, memoryMessageInfo(msg, state))
+			}
+		}
+		sort.Slice(messages, func(i, j int) bool {
+			return messages[i].NextProcessAt.Before(messages[j].NextProcessAt)
+		})
+	}
+	return paginate(messages, page, pageSize), nil
+}
+
+// DeleteMessage 删除等待投递, 延迟以及重试中的消息
+func (m *MemoryQueue) DeleteMessage(ctx context.Context, topic, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, msg := range m.pending {
+		if msg.topic == topic && msg.id == id {
+			m.removePendingLocked(i)
+			m.releaseOrderingLocked(msg)
+			return nil
+		}
+	}
+	if timer, ok := m.timerLocked(topic, id); ok {
+		timer.Stop()
+		m.releaseOrderingLocked(m.timers[timer])
+		delete(m.timers, timer)
+		return nil
+	}
+	return ErrMessageNotFound
+}
+
+// RequeueMessage 将延迟以及重试中的消息立即投递
+func (m *MemoryQueue) RequeueMessage(ctx context.Context, topic, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	timer, ok := m.timerLocked(topic, id)
+	if !ok {
+		return ErrMessageNotFound
+	}
+	// 定时器已经触发时消息已经在投递中
+	if timer.Stop() {
+		msg := m.timers[timer]
+		delete(m.timers, timer)
+		m.enqueueLocked(msg)
+	}
+	return nil
+}
+
+func (m *MemoryQueue) PauseTopic(ctx context.Context, topic string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.paused[topic] = true
+	return nil
+}
+
+func (m *MemoryQueue) UnpauseTopic(ctx context.Context, topic string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.paused, topic)
+	m.cond.Broadcast()
+	return nil
+}
+
+func (m *MemoryQueue) timerLocked(topic, id string) (*time.Timer, bool) {
+	for timer, msg := range m.timers {
+		if msg.topic == topic && msg.id == id {
+			return timer, true
+		}
+	}
+	return nil, false
+}
+
+func memoryMessageInfo(msg *memoryMessage, state MessageState) MessageInfo {
+	info := newMessageInfo(msg.id, msg.topic, state, msg.payload)
+	info.Retried = msg.retried
+	if state != MessageStatePending {
+		info.NextProcessAt = msg.availableAt
+	}
+	return info
+}
@@ -0,0 +1,249 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// pausedTopicsKey 保存暂停的主题的 redis 集合
+	pausedTopicsKey = "queue:paused"
+	// pausedTopicsTTL 订阅方缓存暂停的主题的时间
+	pausedTopicsTTL = time.Second
+	// pausedRetryDelay 暂停的主题中的消息再次尝试投递的间隔
+	pausedRetryDelay = 5 * time.Second
+	// inspectPageSize 遍历队列时每次读取的消息数量
+	inspectPageSize = 500
+)
+
+// errTopicPaused 主题被暂停时订阅方返回的错误. 消息会延迟后再次投递并且不计入重试次数
+var errTopicPaused = errors.New("topic paused")
+
+var _ Inspector = (*RedisInspector)(nil)
+
+type (
+	// RedisInspector redis 队列的 Inspector.
+	// asynq 只按照队列名称统计消息, 因此按照主题统计以及分页时需要遍历队列中的消息, 消息堆积较多时开销较大
+	RedisInspector struct {
+		inspector *asynq.Inspector
+		client    redis.UniversalClient
+		ordering  *redisOrdering
+	}
+
+	// pausedTopics 订阅方缓存的暂停的主题
+	pausedTopics struct {
+		client redis.UniversalClient
+
+		mu       sync.Mutex
+		topics   map[string]bool
+		expireAt time.Time
+	}
+)
+
+func (r *RedisInspector) Topics(ctx context.Context) ([]TopicInfo, error) {
+	queues, err := r.inspector.Queues()
+	if err != nil {
+		return nil, err
+	}
+	paused, err := r.client.SMembers(ctx, pausedTopicsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	topics := make(map[string]*TopicInfo)
+	topicOf := func(topic string) *TopicInfo {
+		info, ok := topics[topic]
+		if !ok {
+			info = &TopicInfo{Topic: topic}
+			topics[topic] = info
+		}
+		return info
+	}
+	for _, topic := range paused {
+		topicOf(topic).Paused = true
+	}
+	for _, queue := range queues {
+		for _, state := range []MessageState{MessageStatePending, MessageStateActive,
+			MessageStateScheduled, MessageStateRetry, MessageStateDead} {
+			err := r.scan(queue, state, func(task *asynq.TaskInfo) bool {
+				info := topicOf(task.Type)
+				switch state {
+				case MessageStatePending:
+					info.Pending++
+				case MessageStateActive:
+					info.Active++
+				case MessageStateScheduled:
+					info.Scheduled++
+				case MessageStateRetry:
+					info.Retry++
+				case MessageStateDead:
+					info.Dead++
+				}
+				return true
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result := make([]TopicInfo, 0, len(topics))
+	for _, info := range topics {
+		result = append(result, *info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Topic < result[j].Topic
+	})
+	return result, nil
+}
+
+func (r *RedisInspector) Messages(ctx context.Context, topic string, state MessageState, page, pageSize int) ([]MessageInfo, error) {
+	if !state.Valid() {
+		return nil, ErrInvalidMessageState
+	}
+	queues, err := r.inspector.Queues()
+	if err != nil {
+		return nil, err
+	}
+	// 只需要读取到第 page 页的最后一条消息
+	limit := max(page, 1) * pageSize
+	messages := make([]MessageInfo, 0)
+	for _, queue := range queues {
+		err := r.scan(queue, state, func(task *asynq.TaskInfo) bool {
+			if task.Type == topic {
+				messages = append(messages, redisMessageInfo(task, state))
+			}
+			return len(messages) < limit
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) >= limit {
+			break
+		}
+	}
+	return paginate(messages, page, pageSize), nil
+}
+
+// DeleteMessage 删除消息. 带有顺序键的消息被删除后, 同一个顺序键之后的消息不再等待该消息
+func (r *RedisInspector) DeleteMessage(ctx context.Context, topic, id string) error {
+	task, err := r.find(topic, id)
+	if err != nil {
+		return err
+	}
+	if err := r.inspector.DeleteTask(task.Queue, id); err != nil {
+		return err
+	}
+	if envelope := decodeEnvelope(task.Payload); envelope.OrderingKey != "" && envelope.Sequence > 0 {
+		return r.ordering.skip(ctx, envelope.Metadata.Get(MetadataSubscription), topic, envelope)
+	}
+	return nil
+}
+
+func (r *RedisInspector) RequeueMessage(ctx context.Context, topic, id string) error {
+	task, err := r.find(topic, id)
+	if err != nil {
+		return err
+	}
+	return r.inspector.RunTask(task.Queue, id)
+}
+
+// PauseTopic 暂停投递主题中的消息. 暂停期间到达的消息会进入重试状态, 恢复后投递, 不计入重试次数
+func (r *RedisInspector) PauseTopic(ctx context.Context, topic string) error {
+	return r.client.SAdd(ctx, pausedTopicsKey, topic).Err()
+}
+
+func (r *RedisInspector) UnpauseTopic(ctx context.Context, topic string) error {
+	return r.client.SRem(ctx, pausedTopicsKey, topic).Err()
+}
+
+// Close 关闭 redis 连接
+func (r *RedisInspector) Close() error {
+	return errors.Join(r.inspector.Close(), r.client.Close())
+}
+
+// find 返回消息的详细信息
+func (r *RedisInspector) find(topic, id string) (*asynq.TaskInfo, error) {
+	queues, err := r.inspector.Queues()
+	if err != nil {
+		return nil, err
+	}
+	for _, queue := range queues {
+		task, err := r.inspector.GetTaskInfo(queue, id)
+		if errors.Is(err, asynq.ErrTaskNotFound) || errors.Is(err, asynq.ErrQueueNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if task.Type == topic {
+			return task, nil
+		}
+	}
+	return nil, ErrMessageNotFound
+}
+
+// scan 遍历队列中指定状态的消息. fn 返回 false 时停止遍历
+func (r *RedisInspector) scan(queue string, state MessageState, fn func(task *asynq.TaskInfo) bool) error {
+	list := map[MessageState]func(string, ...asynq.ListOption) ([]*asynq.TaskInfo, error){
+		MessageStatePending:   r.inspector.ListPendingTasks,
+		MessageStateActive:    r.inspector.ListActiveTasks,
+		MessageStateScheduled: r.inspector.ListScheduledTasks,
+		MessageStateRetry:     r.inspector.ListRetryTasks,
+		MessageStateDead:      r.inspector.ListArchivedTasks,
+	}[state]
+	for page := 1; ; page++ {
+		tasks, err := list(queue, asynq.Page(page), asynq.PageSize(inspectPageSize))
+		if err != nil {
+			return err
+		}
+		for _, task := range tasks {
+			if !fn(task) {
+				return nil
+			}
+		}
+		if len(tasks) < inspectPageSize {
+			return nil
+		}
+	}
+}
+
+func redisMessageInfo(task *asynq.TaskInfo, state MessageState) MessageInfo {
+	info := newMessageInfo(task.ID, task.Type, state, task.Payload)
+	info.Retried = task.Retried
+	info.LastError = task.LastErr
+	info.NextProcessAt = task.NextProcessAt
+	return info
+}
+
+// contains 返回主题是否被暂停. 读取 redis 失败时沿用上一次的结果
+func (p *pausedTopics) contains(ctx context.Context, topic string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now := time.Now(); now.After(p.expireAt) {
+		p.expireAt = now.Add(pausedTopicsTTL)
+		if topics, err := p.client.SMembers(ctx, pausedTopicsKey).Result(); err == nil {
+			p.topics = make(map[string]bool, len(topics))
+			for _, topic := range topics {
+				p.topics[topic] = true
+			}
+		}
+	}
+	return p.topics[topic]
+}
+
+// NewRedisInspector 创建 redis 队列的 Inspector. config 与 NewRedisQueueWithConfig 使用的配置一致
+func NewRedisInspector(config RedisQueueConfig) *RedisInspector {
+	redisClientOpt := config.redisClientOpt()
+	client := redisClientOpt.MakeRedisClient().(redis.UniversalClient)
+	return &RedisInspector{
+		inspector: asynq.NewInspector(redisClientOpt),
+		client:    client,
+		ordering:  &redisOrdering{client: client},
+	}
+}
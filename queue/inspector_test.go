@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+func TestMemoryQueue_Inspector(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1)
+	inspector := queue.(Inspector)
+	received := make(chan string, 2)
+	queue.SubscribeTo("order", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		received <- string(message)
+		return nil
+	}))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	ctx := context.Background()
+	assert.Equal(t, nil, inspector.PauseTopic(ctx, "order"))
+	assert.Equal(t, nil, queue.Publish(ctx, "order", []byte("foo")))
+	assert.Equal(t, nil, queue.Publish(ctx, "order", []byte("bar"), WithMessageID("delayed"), WithDelay(time.Hour)))
+	select {
+	case <-received:
+		t.Fatal("message of paused topic consumed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	topics, err := inspector.Topics(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []TopicInfo{{Topic: "order", Pending: 1, Scheduled: 1, Paused: true}}, topics)
+
+	scheduled, err := inspector.Messages(ctx, "order", MessageStateScheduled, 1, 10)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(scheduled))
+	assert.Equal(t, "delayed", scheduled[0].ID)
+	assert.T(t, scheduled[0].NextProcessAt.After(time.Now()))
+
+	assert.Equal(t, nil, inspector.UnpauseTopic(ctx, "order"))
+	assert.Equal(t, "foo", waitMessage(t, received))
+	assert.Equal(t, nil, inspector.RequeueMessage(ctx, "order", "delayed"))
+	assert.Equal(t, "bar", waitMessage(t, received))
+	assert.Equal(t, ErrMessageNotFound, inspector.DeleteMessage(ctx, "order", "delayed"))
+}
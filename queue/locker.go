@@ -0,0 +1,38 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// Locker 锁. 用于多个副本之间的互斥, 例如定时任务只由一个副本触发
+	Locker interface {
+		// TryLock 尝试获取锁. 获取成功返回 true, 锁在 ttl 后自动释放
+		TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	}
+
+	memoryLocker struct {
+		mu    sync.Mutex
+		locks map[string]time.Time
+	}
+)
+
+func (m *memoryLocker) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if expireAt, ok := m.locks[key]; ok && now.Before(expireAt) {
+		return false, nil
+	}
+	m.locks[key] = now.Add(ttl)
+	return true, nil
+}
+
+// NewMemoryLocker 创建基于内存的锁. 仅在当前进程内生效
+func NewMemoryLocker() Locker {
+	return &memoryLocker{
+		locks: make(map[string]time.Time),
+	}
+}
@@ -0,0 +1,31 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultRedisLockerPrefix = "queue:lock:"
+
+type redisLocker struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func (r *redisLocker) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.prefix+key, 1, ttl).Result()
+}
+
+// NewRedisLocker 创建基于 redis 的锁. 多个副本之间互斥
+// prefix 为 redis key 的前缀, 为空时使用 queue:lock:
+func NewRedisLocker(client redis.UniversalClient, prefix string) Locker {
+	if prefix == "" {
+		prefix = defaultRedisLockerPrefix
+	}
+	return &redisLocker{
+		client: client,
+		prefix: prefix,
+	}
+}
@@ -0,0 +1,420 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+var (
+	ErrQueueClosed       = errors.New("queue closed")
+	ErrSubscriberStarted = errors.New("subscriber already started")
+)
+
+type (
+	// MemoryQueue 基于内存的队列实现. 消息只在当前进程内流转, 进程退出后未消费的消息会丢失.
+	// 适用于单元测试以及不需要依赖外部基础设施的单进程服务
+	MemoryQueue struct {
+		logger      logger.Logger
+		concurrency int
+		options     []Option
+		// unique WithUnique 的去重存储
+		unique DedupStore
+
+		mu      sync.Mutex
+		cond    *sync.Cond
+		router  *router
+		pending []*memoryMessage
+		timers  map[*time.Timer]*memoryMessage
+		active  map[string]int
+		paused  map[string]bool
+		// ordering 每个顺序键尚未完成的消息, 按照发布的顺序排列. 只有排在最前面的消息可以被取出
+		ordering map[string][]*memoryMessage
+		started  bool
+		stopped  bool
+		done     chan struct{}
+		workers  sync.WaitGroup
+
+		// baseCtx 所有消息处理的 context 的父 context. 停止订阅超时时取消
+		baseCtx    context.Context
+		cancelBase context.CancelFunc
+		requeued   atomic.Int64
+	}
+
+	memoryMessage struct {
+		id       string
+		topic    string
+		payload  []byte
+		retried  int
+		maxRetry int
+		// subscription 消息副本所属的订阅者标识. 为空时按照主题匹配订阅者
+		subscription string
+		// availableAt 延迟消息以及重试消息下一次投递的时间
+		availableAt time.Time
+		// orderingKey 消息的顺序键. 为空时不保证顺序
+		orderingKey string
+	}
+)
+
+func (m *MemoryQueue) Publish(ctx context.Context, topic string, message any, opts ...Option) error {
+	_, err := m.publish(ctx, topic, message, newOptions(mergeOptions(m.options, opts)))
+	return err
+}
+
+// PublishBatch 批量发布消息. 消息按照顺序依次投递
+func (m *MemoryQueue) PublishBatch(ctx context.Context, topic string, messages []any, opts ...Option) ([]PublishResult, error) {
+	options := newOptions(mergeOptions(m.options, opts))
+	return publishBatch(ctx, messages, 1, func(ctx context.Context, message any) (string, error) {
+		return m.publish(ctx, topic, message, options)
+	})
+}
+
+func (m *MemoryQueue) publish(ctx context.Context, topic string, message any, options *options) (string, error) {
+	ctx, span := startPublishSpan(ctx, topic)
+	defer span.End()
+
+	messageID, data, err := encodeMessage(ctx, options, message)
+	if err != nil {
+		return "", err
+	}
+	release, err := acquireUnique(ctx, m.unique, topic, options)
+	if err != nil {
+		recordPublish(ctx, topic, err)
+		return "", err
+	}
+	msg := &memoryMessage{
+		id:          messageID,
+		topic:       topic,
+		payload:     data,
+		maxRetry:    options.maxRetry,
+		orderingKey: options.orderingKey,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		release()
+		recordPublish(ctx, topic, ErrQueueClosed)
+		return "", ErrQueueClosed
+	}
+	recordPublish(ctx, topic, nil)
+	m.trackOrderingLocked(msg)
+	if delay := time.Until(options.availableAt()); delay > 0 {
+		m.scheduleLocked(msg, delay)
+	} else {
+		m.enqueueLocked(msg)
+	}
+	m.logger.Info(ctx, "published message to memory queue success",
+		zap.ByteString("payload", data), zap.String("message_id", messageID))
+	return messageID, nil
+}
+
+func (m *MemoryQueue) SubscribeTo(topic string, subscriber Subscriber, opts ...Option) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.router.add(topic, newSubscription(subscriber, newOptions(mergeOptions(m.options, opts))))
+}
+
+func (m *MemoryQueue) StartSubscriber() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return ErrQueueClosed
+	}
+	if m.started {
+		return ErrSubscriberStarted
+	}
+	m.started = true
+	for i := 0; i < m.concurrency; i++ {
+		m.workers.Add(1)
+		go m.work()
+	}
+	return nil
+}
+
+// RunSubscriber 同步启动订阅. 阻塞直到收到退出信号或者 Shutdown 被调用
+func (m *MemoryQueue) RunSubscriber() error {
+	if err := m.StartSubscriber(); err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	select {
+	case <-signals:
+		return m.Shutdown(context.Background())
+	case <-m.done:
+	}
+	return nil
+}
+
+// Shutdown 停止订阅. 等待正在处理的消息完成. 尚未投递的消息以及延迟消息会被丢弃.
+// ctx 结束时取消正在处理的消息的 context, 等待订阅者返回后返回 *ShutdownError
+func (m *MemoryQueue) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return nil
+	}
+	m.stopped = true
+	for timer := range m.timers {
+		timer.Stop()
+	}
+	m.timers = nil
+	m.cond.Broadcast()
+	m.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(stopped)
+	}()
+	var err error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		m.cancelBase()
+		<-stopped
+		err = &ShutdownError{Requeued: int(m.requeued.Load()), Err: ctx.Err()}
+	}
+	m.cancelBase()
+	close(m.done)
+	return err
+}
+
+// Close 内存队列没有需要释放的连接, 总是返回 nil
+func (m *MemoryQueue) Close() error {
+	return nil
+}
+
+func (m *MemoryQueue) scheduleLocked(msg *memoryMessage, delay time.Duration) {
+	msg.availableAt = time.Now().Add(delay)
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		// 定时器触发后等待锁期间消息可能已经被删除或者立即投递
+		if _, ok := m.timers[timer]; !ok || m.stopped {
+			return
+		}
+		delete(m.timers, timer)
+		m.enqueueLocked(msg)
+	})
+	m.timers[timer] = msg
+}
+
+func (m *MemoryQueue) enqueueLocked(msg *memoryMessage) {
+	m.pending = append(m.pending, msg)
+	m.cond.Signal()
+}
+
+// next 返回下一条可以处理的消息. 暂停的主题中的消息以及顺序键正在被处理的消息会保留在队列中
+func (m *MemoryQueue) next() (*memoryMessage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for !m.stopped {
+		for i, msg := range m.pending {
+			if m.paused[msg.topic] {
+				continue
+			}
+			if key := msg.orderingLockKey(); key != "" && len(m.ordering[key]) > 0 && m.ordering[key][0] != msg {
+				continue
+			}
+			m.removePendingLocked(i)
+			m.active[msg.topic]++
+			return msg, true
+		}
+		m.cond.Wait()
+	}
+	return nil, false
+}
+
+func (m *MemoryQueue) work() {
+	defer m.workers.Done()
+	for {
+		msg, ok := m.next()
+		if !ok {
+			return
+		}
+		retrying := m.dispatch(m.baseCtx, msg)
+		m.mu.Lock()
+		if m.active[msg.topic]--; m.active[msg.topic] == 0 {
+			delete(m.active, msg.topic)
+		}
+		if !retrying {
+			m.releaseOrderingLocked(msg)
+		}
+		m.mu.Unlock()
+	}
+}
+
+func (m *MemoryQueue) removePendingLocked(i int) {
+	if i == 0 {
+		m.pending[0] = nil
+		m.pending = m.pending[1:]
+		return
+	}
+	m.pending = append(m.pending[:i], m.pending[i+1:]...)
+}
+
+// dispatch 将消息投递给匹配的订阅者. 匹配到多个订阅者时为每个订阅者生成一个副本重新入队.
+// 返回消息是否会被重新投递
+func (m *MemoryQueue) dispatch(ctx context.Context, msg *memoryMessage) bool {
+	subscriptions := m.router.route(msg.topic, msg.subscription)
+	switch len(subscriptions) {
+	case 0:
+		m.logger.Warn(ctx, "no subscriber for memory queue topic, message dropped",
+			zap.String("topic", msg.topic), zap.ByteString("payload", msg.payload))
+	case 1:
+		return m.handle(ctx, msg, subscriptions[0])
+	default:
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.stopped {
+			return false
+		}
+		for _, subscription := range subscriptions {
+			copied := &memoryMessage{
+				id:           msg.id,
+				topic:        msg.topic,
+				payload:      msg.payload,
+				maxRetry:     msg.maxRetry,
+				subscription: subscription.key,
+				orderingKey:  msg.orderingKey,
+			}
+			m.trackOrderingLocked(copied)
+			m.enqueueLocked(copied)
+		}
+	}
+	return false
+}
+
+// handle 将消息投递给订阅者. 返回消息是否会被重试
+func (m *MemoryQueue) handle(ctx context.Context, msg *memoryMessage, subscription *subscription) bool {
+	ctx, envelope, err := subscription.consume(ctx, msg.topic, msg.payload)
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrMessageExpired) {
+		m.logger.Warn(ctx, "memory queue message expired, message dropped",
+			zap.String("topic", msg.topic), zap.String("message_id", msg.id))
+		return false
+	}
+	if m.baseCtx.Err() != nil {
+		// 停止订阅超时被取消的消息不再重试
+		m.requeued.Add(1)
+		return false
+	}
+	if errors.Is(err, errConcurrencyLimited) {
+		return m.postpone(msg, concurrencyRetryDelay)
+	}
+	m.logger.Error(ctx, "failed to handle memory queue message", err,
+		zap.String("topic", msg.topic), zap.ByteString("payload", msg.payload), zap.Int("retried", msg.retried))
+
+	options := subscription.options
+	if !subscription.exhausted(msg.retried, msg.maxRetry, err) {
+		return m.retry(msg, options.retryBackoff(msg.retried, err))
+	}
+	if deadLetterTopic := subscription.deadLetterTopicOf(msg.topic, err); deadLetterTopic != "" {
+		if dlqErr := publishDeadLetter(ctx, m, deadLetterTopic, msg.topic, envelope, msg.retried, err); dlqErr != nil {
+			m.logger.Error(ctx, "failed to publish message to dead letter topic", dlqErr,
+				zap.String("topic", msg.topic), zap.String("dead_letter_topic", deadLetterTopic))
+		}
+		return false
+	}
+	m.logger.Warn(ctx, "memory queue message retry exhausted, message dropped",
+		zap.String("topic", msg.topic), zap.ByteString("payload", msg.payload))
+	return false
+}
+
+func (m *MemoryQueue) retry(msg *memoryMessage, backoff time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return false
+	}
+	msg.retried++
+	m.scheduleLocked(msg, backoff)
+	return true
+}
+
+// postpone 在 delay 后重新投递消息, 不计入重试次数
+func (m *MemoryQueue) postpone(msg *memoryMessage, delay time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return false
+	}
+	m.scheduleLocked(msg, delay)
+	return true
+}
+
+// trackOrderingLocked 记录带有顺序键的消息的发布顺序
+func (m *MemoryQueue) trackOrderingLocked(msg *memoryMessage) {
+	if key := msg.orderingLockKey(); key != "" {
+		m.ordering[key] = append(m.ordering[key], msg)
+	}
+}
+
+// releaseOrderingLocked 消息完成或者被删除后将其从顺序键中移除, 投递同一个顺序键的下一条消息
+func (m *MemoryQueue) releaseOrderingLocked(msg *memoryMessage) {
+	key := msg.orderingLockKey()
+	if key == "" {
+		return
+	}
+	messages := m.ordering[key]
+	for i, target := range messages {
+		if target == msg {
+			messages = append(messages[:i], messages[i+1:]...)
+			break
+		}
+	}
+	if len(messages) == 0 {
+		delete(m.ordering, key)
+	} else {
+		m.ordering[key] = messages
+	}
+	m.cond.Broadcast()
+}
+
+// orderingLockKey 返回消息的顺序键在订阅者中的标识. 消息没有顺序键时返回空字符串
+func (msg *memoryMessage) orderingLockKey() string {
+	if msg.orderingKey == "" {
+		return ""
+	}
+	return msg.topic + "\x00" + msg.subscription + "\x00" + msg.orderingKey
+}
+
+// NewMemoryQueue 创建基于内存的队列
+// concurrency 为处理消息的 goroutine 数量. 小于等于0时使用 CPU 核数
+// opts 会作为该队列所有发布以及订阅的默认选项
+func NewMemoryQueue(logger logger.Logger, concurrency int, opts ...Option) Queue {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	queue := &MemoryQueue{
+		logger:      logger,
+		concurrency: concurrency,
+		options:     opts,
+		unique:      NewMemoryDedupStore(),
+		router:      newRouter(),
+		timers:      make(map[*time.Timer]*memoryMessage),
+		active:      make(map[string]int),
+		paused:      make(map[string]bool),
+		ordering:    make(map[string][]*memoryMessage),
+		done:        make(chan struct{}),
+	}
+	queue.cond = sync.NewCond(&queue.mu)
+	queue.baseCtx, queue.cancelBase = context.WithCancel(context.Background())
+	return queue
+}
@@ -0,0 +1,283 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+type testMessage struct {
+	Content string
+}
+
+func (t *testMessage) MarshalBinary() ([]byte, error) {
+	return []byte(t.Content), nil
+}
+
+func (t *testMessage) UnmarshalBinary(data []byte) error {
+	t.Content = string(data)
+	return nil
+}
+
+func TestMemoryQueue(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 2)
+	received := make(chan string, 2)
+	queue.SubscribeTo("bytes", CreateSubscriber(func(ctx context.Context, topic string, message []byte) error {
+		received <- string(message)
+		return nil
+	}))
+	queue.SubscribeTo("binary", CreateSubscriber(func(ctx context.Context, topic string, message *testMessage) error {
+		received <- message.Content
+		return nil
+	}))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	ctx := context.Background()
+	assert.Equal(t, nil, queue.Publish(ctx, "bytes", []byte("foo")))
+	assert.Equal(t, "foo", waitMessage(t, received))
+	assert.Equal(t, nil, queue.Publish(ctx, "binary", &testMessage{Content: "bar"}))
+	assert.Equal(t, "bar", waitMessage(t, received))
+	assert.NotEqual(t, nil, queue.Publish(ctx, "bytes", "unsupported"))
+}
+
+func TestMemoryQueue_WithDelay(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1)
+	received := make(chan time.Time, 1)
+	queue.SubscribeTo("delay", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		received <- time.Now()
+		return nil
+	}))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	publishedAt := time.Now()
+	assert.Equal(t, nil, queue.Publish(context.Background(), "delay", []byte("foo"), WithDelay(100*time.Millisecond)))
+	select {
+	case consumedAt := <-received:
+		assert.T(t, consumedAt.Sub(publishedAt) >= 100*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Fatal("delayed message not consumed")
+	}
+}
+
+func TestMemoryQueue_Shutdown(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1)
+	assert.Equal(t, nil, queue.StartSubscriber())
+	assert.Equal(t, ErrSubscriberStarted, queue.StartSubscriber())
+	queue.Shutdown(context.Background())
+	err := queue.Publish(context.Background(), "foo", []byte("bar"))
+	assert.T(t, errors.Is(err, ErrQueueClosed))
+}
+
+func TestMemoryQueue_ShutdownTimeout(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1)
+	started := make(chan struct{})
+	queue.SubscribeTo("slow", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	assert.Equal(t, nil, queue.Publish(context.Background(), "slow", []byte("foo")))
+	waitMessage(t, started)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := queue.Shutdown(ctx)
+	var shutdownErr *ShutdownError
+	assert.T(t, errors.As(err, &shutdownErr))
+	assert.Equal(t, 1, shutdownErr.Requeued)
+	assert.T(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func waitMessage[T any](t *testing.T, received <-chan T) T {
+	t.Helper()
+	select {
+	case message := <-received:
+		return message
+	case <-time.After(3 * time.Second):
+		t.Fatal("message not consumed")
+	}
+	var zero T
+	return zero
+}
+
+func TestMemoryQueue_RetryAndDeadLetter(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1)
+	attempts := 0
+	queue.SubscribeTo("order", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		attempts++
+		return errors.New("boom")
+	}), WithMaxRetry(2), WithRetryBackoff(ConstantBackoff(time.Millisecond)), WithDeadLetter())
+	deadLetters := make(chan *DeadLetter, 1)
+	queue.SubscribeTo(DeadLetterTopic("order"), CreateSubscriber(func(ctx context.Context, topic string, message *DeadLetter) error {
+		deadLetters <- message
+		return nil
+	}))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	assert.Equal(t, nil, queue.Publish(context.Background(), "order", []byte("foo")))
+	deadLetter := waitMessage(t, deadLetters)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, "order", deadLetter.Topic)
+	assert.Equal(t, "foo", string(deadLetter.Payload))
+	assert.Equal(t, "boom", deadLetter.Error)
+	assert.Equal(t, 2, deadLetter.Retried)
+}
+
+func TestMemoryQueue_WithConcurrency(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 4)
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	done := make(chan struct{}, 4)
+	queue.SubscribeTo("bulk", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		done <- struct{}{}
+		return nil
+	}), WithConcurrency(1))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, nil, queue.Publish(context.Background(), "bulk", []byte("foo")))
+	}
+	for i := 0; i < 4; i++ {
+		waitMessage(t, done)
+	}
+	assert.Equal(t, 1, maxRunning)
+}
+
+func TestMemoryQueue_WithConcurrencyNotStarve(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 2)
+	release := make(chan struct{})
+	received := make(chan string, 4)
+	queue.SubscribeTo("bulk", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		<-release
+		received <- topic
+		return nil
+	}), WithConcurrency(1))
+	queue.SubscribeTo("urgent", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		received <- topic
+		return nil
+	}))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, nil, queue.Publish(context.Background(), "bulk", []byte("foo")))
+	}
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, nil, queue.Publish(context.Background(), "urgent", []byte("bar")))
+	assert.Equal(t, "urgent", waitMessage(t, received))
+	close(release)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "bulk", waitMessage(t, received))
+	}
+}
+
+func TestMemoryQueue_PublishOptions(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1)
+	received := make(chan string, 2)
+	deadlines := make(chan time.Duration, 1)
+	queue.SubscribeTo("order", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		if deadline, ok := ctx.Deadline(); ok {
+			deadlines <- time.Until(deadline)
+		}
+		received <- string(message)
+		return nil
+	}))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	ctx := context.Background()
+	assert.Equal(t, nil, queue.Publish(ctx, "order", []byte("foo"), WithUnique("order-1", time.Minute), WithTTL(time.Nanosecond)))
+	assert.Equal(t, ErrDuplicateMessage, queue.Publish(ctx, "order", []byte("foo"), WithUnique("order-1", time.Minute)))
+	assert.Equal(t, nil, queue.Publish(ctx, "order", []byte("bar"), WithUnique("order-2", time.Minute), WithTimeout(time.Minute)))
+	assert.Equal(t, ErrInvalidUniqueWindow, queue.Publish(ctx, "order", []byte("bar"), WithUnique("order-3", 0)))
+	assert.Equal(t, "bar", waitMessage(t, received))
+	timeout := waitMessage(t, deadlines)
+	assert.T(t, timeout > 0 && timeout <= time.Minute)
+}
+
+func TestMemoryQueue_WithOrderingKey(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 4)
+	var mu sync.Mutex
+	received := make(map[string][]string)
+	failed := false
+	done := make(chan struct{}, 6)
+	queue.SubscribeTo("order", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		// 第一条消息失败一次, 同一个顺序键之后的消息需要等待其重试成功
+		if string(message) == "a-1" && !failed {
+			failed = true
+			return errors.New("boom")
+		}
+		key := string(message[:1])
+		received[key] = append(received[key], string(message))
+		done <- struct{}{}
+		return nil
+	}), WithRetryBackoff(ConstantBackoff(20*time.Millisecond)))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		for _, key := range []string{"a", "b"} {
+			message := fmt.Sprintf("%s-%d", key, i)
+			assert.Equal(t, nil, queue.Publish(ctx, "order", []byte(message), WithOrderingKey(key)))
+		}
+	}
+	for i := 0; i < 6; i++ {
+		waitMessage(t, done)
+	}
+	assert.Equal(t, []string{"a-1", "a-2", "a-3"}, received["a"])
+	assert.Equal(t, []string{"b-1", "b-2", "b-3"}, received["b"])
+}
+
+func TestMemoryQueue_DeleteFiredTimer(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1).(*MemoryQueue)
+	ctx := context.Background()
+	assert.Equal(t, nil, queue.Publish(ctx, "order", []byte("foo"), WithOrderingKey("order-1"), WithDelay(time.Hour)))
+
+	// 定时器触发后在等待锁期间消息被删除
+	queue.mu.Lock()
+	for timer, msg := range queue.timers {
+		timer.Reset(0)
+		time.Sleep(10 * time.Millisecond)
+		queue.releaseOrderingLocked(msg)
+		delete(queue.timers, timer)
+	}
+	queue.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	queue.mu.Lock()
+	assert.Equal(t, 0, len(queue.pending))
+	queue.mu.Unlock()
+
+	// 顺序键已经被释放的消息不会导致 next panic
+	queue.mu.Lock()
+	msg := &memoryMessage{id: "order-2", topic: "order", orderingKey: "order-2"}
+	queue.enqueueLocked(msg)
+	queue.mu.Unlock()
+	next, ok := queue.next()
+	assert.Equal(t, true, ok)
+	assert.Equal(t, msg, next)
+}
@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+
+	commonmetric "github.com/chaihaobo/gocommon/metric"
+)
+
+const (
+	// MetricPublished 发布消息的次数
+	MetricPublished = "queue.published"
+	// MetricConsumed 订阅者处理消息的次数. 通过 status 标签区分成功与失败
+	MetricConsumed = "queue.consumed"
+	// MetricConsumeDuration 订阅者处理消息的耗时(毫秒)
+	MetricConsumeDuration = "queue.consume.duration"
+	// MetricConsumeLatency 消息从可被消费到开始处理的延迟(毫秒)
+	MetricConsumeLatency = "queue.consume.latency"
+
+	LabelStatus = "status"
+
+	statusSuccess = "success"
+	statusFailure = "failure"
+)
+
+var (
+	//	DefaultTelemetryBucketBoundaries 100ms 500ms 1s 2s 3s 4s
+	DefaultTelemetryBucketBoundaries = []float64{
+		100,
+		500,
+		float64(time.Second.Milliseconds() * 1),
+		float64(time.Second.Milliseconds() * 2),
+		float64(time.Second.Milliseconds() * 3),
+		float64(time.Second.Milliseconds() * 4),
+	}
+)
+
+func statusOf(err error) string {
+	if err != nil {
+		return statusFailure
+	}
+	return statusSuccess
+}
+
+// recordPublish 记录发布消息的指标
+func recordPublish(ctx context.Context, topic string, publishErr error) {
+	meter := otel.Meter(commonmetric.DefaultMeterName)
+	if counter, err := meter.Int64Counter(MetricPublished); err == nil {
+		counter.Add(ctx, 1, metric.WithAttributes(
+			semconv.MessagingDestinationName(topic),
+			attribute.String(LabelStatus, statusOf(publishErr)),
+		))
+	}
+}
+
+// recordConsume 记录订阅者处理消息的指标
+// startTime 为开始处理的时间, availableAt 为消息可被消费的时间
+func recordConsume(ctx context.Context, topic string, startTime, availableAt time.Time, consumeErr error) {
+	meter := otel.Meter(commonmetric.DefaultMeterName)
+	topicAttr := semconv.MessagingDestinationName(topic)
+	attrs := []attribute.KeyValue{topicAttr, attribute.String(LabelStatus, statusOf(consumeErr))}
+	if counter, err := meter.Int64Counter(MetricConsumed); err == nil {
+		counter.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+	if histogram, err := meter.Int64Histogram(MetricConsumeDuration,
+		metric.WithExplicitBucketBoundaries(DefaultTelemetryBucketBoundaries...)); err == nil {
+		histogram.Record(ctx, time.Since(startTime).Milliseconds(), metric.WithAttributes(attrs...))
+	}
+	if availableAt.IsZero() {
+		return
+	}
+	if histogram, err := meter.Int64Histogram(MetricConsumeLatency,
+		metric.WithExplicitBucketBoundaries(DefaultTelemetryBucketBoundaries...)); err == nil {
+		histogram.Record(ctx, startTime.Sub(availableAt).Milliseconds(), metric.WithAttributes(topicAttr))
+	}
+}
@@ -0,0 +1,56 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bmizerany/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+func TestMemoryQueue_Metric(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	defer otel.SetMeterProvider(noop.NewMeterProvider())
+
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1)
+	done := make(chan struct{}, 2)
+	queue.SubscribeTo("order", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		defer func() { done <- struct{}{} }()
+		if string(message) == "fail" {
+			return errors.New("boom")
+		}
+		return nil
+	}), WithMaxRetry(0))
+	assert.Equal(t, nil, queue.StartSubscriber())
+
+	ctx := context.Background()
+	assert.Equal(t, nil, queue.Publish(ctx, "order", []byte("ok")))
+	assert.Equal(t, nil, queue.Publish(ctx, "order", []byte("fail")))
+	waitMessage(t, done)
+	waitMessage(t, done)
+	queue.Shutdown(context.Background())
+
+	var data metricdata.ResourceMetrics
+	assert.Equal(t, nil, reader.Collect(ctx, &data))
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, scope := range data.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	published := metrics[MetricPublished].(metricdata.Sum[int64])
+	assert.Equal(t, int64(2), published.DataPoints[0].Value)
+	consumed := metrics[MetricConsumed].(metricdata.Sum[int64])
+	assert.Equal(t, 2, len(consumed.DataPoints))
+	_, ok := metrics[MetricConsumeDuration]
+	assert.T(t, ok)
+	_, ok = metrics[MetricConsumeLatency]
+	assert.T(t, ok)
+}
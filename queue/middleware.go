@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+const (
+	LabelQueueTopic   = "queue.topic"
+	LabelQueuePayload = "queue.payload"
+	LabelQueueElapsed = "queue.elapsed"
+)
+
+// SubscriberMiddleware 订阅者中间件. 用于为订阅者添加通用的处理逻辑, 例如 panic 恢复, 日志, 超时等
+type SubscriberMiddleware func(next Subscriber) Subscriber
+
+// Chain 将多个中间件组合为一个中间件. 第一个中间件位于最外层, 最先执行
+func Chain(middlewares ...SubscriberMiddleware) SubscriberMiddleware {
+	return func(next Subscriber) Subscriber {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// RecoveryMiddleware 恢复订阅者的 panic 并转换为错误返回, 消息会按照重试策略重试
+func RecoveryMiddleware(logger logger.Logger) SubscriberMiddleware {
+	return func(next Subscriber) Subscriber {
+		return SubscriberFunc(func(ctx context.Context, topic string, message []byte) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("subscriber panic: %v", r)
+					logger.Error(ctx, "queue subscriber panic", err,
+						zap.String(LabelQueueTopic, topic), zap.ByteString("stack", debug.Stack()))
+				}
+			}()
+			return next.Subscribe(ctx, topic, message)
+		})
+	}
+}
+
+// LoggingMiddleware 记录订阅者处理的消息, 耗时以及错误
+func LoggingMiddleware(logger logger.Logger) SubscriberMiddleware {
+	return func(next Subscriber) Subscriber {
+		return SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+			startTime := time.Now()
+			err := next.Subscribe(ctx, topic, message)
+			fields := []zap.Field{
+				zap.String(LabelQueueTopic, topic),
+				zap.ByteString(LabelQueuePayload, message),
+				zap.Int64(LabelQueueElapsed, time.Since(startTime).Milliseconds()),
+			}
+			if err != nil {
+				logger.Error(ctx, "Queue Consume", err, fields...)
+				return err
+			}
+			logger.Info(ctx, "Queue Consume", fields...)
+			return nil
+		})
+	}
+}
+
+// TimeoutMiddleware 为订阅者的 context 设置超时时间. 订阅者需要根据 context 的取消信号及时退出
+func TimeoutMiddleware(timeout time.Duration) SubscriberMiddleware {
+	return func(next Subscriber) Subscriber {
+		return SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next.Subscribe(ctx, topic, message)
+		})
+	}
+}
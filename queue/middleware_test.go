@@ -0,0 +1,73 @@
+package queue
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	middleware := func(name string) SubscriberMiddleware {
+		return func(next Subscriber) Subscriber {
+			return SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+				calls = append(calls, name)
+				return next.Subscribe(ctx, topic, message)
+			})
+		}
+	}
+	subscriber := Chain(middleware("first"), middleware("second"))(SubscriberFunc(
+		func(ctx context.Context, topic string, message []byte) error {
+			calls = append(calls, "subscriber")
+			return nil
+		}))
+	assert.Equal(t, nil, subscriber.Subscribe(context.Background(), "foo", nil))
+	assert.Equal(t, []string{"first", "second", "subscriber"}, calls)
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	subscriber := RecoveryMiddleware(logger.NewNoopLogger())(SubscriberFunc(
+		func(ctx context.Context, topic string, message []byte) error {
+			panic("boom")
+		}))
+	err := subscriber.Subscribe(context.Background(), "foo", nil)
+	assert.NotEqual(t, nil, err)
+	assert.T(t, strings.Contains(err.Error(), "boom"))
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	subscriber := TimeoutMiddleware(10 * time.Millisecond)(SubscriberFunc(
+		func(ctx context.Context, topic string, message []byte) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}))
+	assert.Equal(t, context.DeadlineExceeded, subscriber.Subscribe(context.Background(), "foo", nil))
+}
+
+func TestMemoryQueue_SubscriberMiddlewares(t *testing.T) {
+	var calls []string
+	middleware := func(name string) SubscriberMiddleware {
+		return func(next Subscriber) Subscriber {
+			return SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+				calls = append(calls, name)
+				return next.Subscribe(ctx, topic, message)
+			})
+		}
+	}
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1, WithSubscriberMiddlewares(middleware("queue")))
+	done := make(chan struct{}, 1)
+	queue.SubscribeTo("foo", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		done <- struct{}{}
+		return nil
+	}), WithSubscriberMiddlewares(middleware("topic")))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	assert.Equal(t, nil, queue.Publish(context.Background(), "foo", []byte("bar")))
+	waitMessage(t, done)
+	queue.Shutdown(context.Background())
+	assert.Equal(t, []string{"queue", "topic"}, calls)
+}
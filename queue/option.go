@@ -5,21 +5,233 @@ import (
 )
 
 type (
+	// Option 队列选项. 发布消息时以及注册订阅者时均可使用, 每个选项在注释中说明其生效的位置.
+	// 创建队列时传入的选项会作为该队列所有发布以及订阅的默认选项
 	Option interface {
 		apply(*options)
 	}
 	OptionFunc func(*options)
 	options    struct {
-		delayDuration time.Duration
+		delayDuration   time.Duration
+		processAt       time.Time
+		maxRetry        int
+		retryBackoff    RetryBackoff
+		deadLetter      bool
+		deadLetterTopic string
+		codec           Codec
+		metadata        Metadata
+		middlewares     []SubscriberMiddleware
+		messageID       string
+		queueName       string
+		concurrency     int
+		group           string
+		ttl             time.Duration
+		uniqueKey       string
+		uniqueWindow    time.Duration
+		deadline        time.Time
+		timeout         time.Duration
+		orderingKey     string
 	}
 )
 
 func (o OptionFunc) apply(options *options) {
-	o.apply(options)
+	o(options)
 }
 
+// mergeOptions 合并队列的默认选项以及本次调用的选项. 本次调用的选项优先
+func mergeOptions(defaults []Option, opts []Option) []Option {
+	if len(defaults) == 0 {
+		return opts
+	}
+	return append(defaults[:len(defaults):len(defaults)], opts...)
+}
+
+func newOptions(opts []Option) *options {
+	options := &options{
+		maxRetry:     DefaultMaxRetry,
+		retryBackoff: DefaultRetryBackoff,
+		codec:        BinaryCodec,
+		metadata:     Metadata{},
+	}
+	for _, opt := range opts {
+		opt.apply(options)
+	}
+	return options
+}
+
+// availableAt 返回消息可被消费的时间
+func (o *options) availableAt() time.Time {
+	if !o.processAt.IsZero() {
+		return o.processAt
+	}
+	return time.Now().Add(o.delayDuration)
+}
+
+// deadLetterTopicOf 返回 topic 对应的死信主题. 未开启死信时返回空字符串
+func (o *options) deadLetterTopicOf(topic string) string {
+	if o.deadLetterTopic != "" {
+		return o.deadLetterTopic
+	}
+	if o.deadLetter {
+		return DeadLetterTopic(topic)
+	}
+	return ""
+}
+
+// WithDelay 延迟投递消息. 发布消息时生效
 func WithDelay(duration time.Duration) Option {
 	return OptionFunc(func(o *options) {
 		o.delayDuration = duration
 	})
 }
+
+// WithProcessAt 在指定的时间投递消息. 同时指定 WithDelay 时以 WithProcessAt 为准. 发布消息时生效
+func WithProcessAt(processAt time.Time) Option {
+	return OptionFunc(func(o *options) {
+		o.processAt = processAt
+	})
+}
+
+// WithTTL 消息的有效期. 从消息可被消费的时间开始计算, 过期后仍未被处理的消息会被丢弃. 发布消息时生效
+func WithTTL(ttl time.Duration) Option {
+	return OptionFunc(func(o *options) {
+		o.ttl = ttl
+	})
+}
+
+// WithUnique 消息去重. 在 window 时间内发布到同一个主题的相同 key 的消息会被拒绝, Publish 返回 ErrDuplicateMessage.
+// 发布失败时会释放 key. window 必须大于 0, 否则 Publish 返回 ErrInvalidUniqueWindow. 发布消息时生效
+func WithUnique(key string, window time.Duration) Option {
+	return OptionFunc(func(o *options) {
+		o.uniqueKey = key
+		o.uniqueWindow = window
+	})
+}
+
+// WithDeadline 订阅者处理消息的截止时间. 订阅者可以通过 ctx.Deadline() 获取. 发布消息时生效
+func WithDeadline(deadline time.Time) Option {
+	return OptionFunc(func(o *options) {
+		o.deadline = deadline
+	})
+}
+
+// WithTimeout 订阅者每次处理消息的超时时间, 每次重试重新计算. 订阅者可以通过 ctx.Deadline() 获取. 发布消息时生效
+func WithTimeout(timeout time.Duration) Option {
+	return OptionFunc(func(o *options) {
+		o.timeout = timeout
+	})
+}
+
+// WithOrderingKey 指定消息的顺序键. 发布到同一个主题的相同顺序键的消息按照发布的顺序依次投递给每个订阅者,
+// 前一条消息处理成功, 进入死信或者被丢弃之后才会投递下一条, 不同顺序键的消息之间仍然并行处理.
+// 延迟消息同样按照发布的顺序投递, 在其投递之前会阻塞之后发布的相同顺序键的消息. 发布消息时生效
+func WithOrderingKey(key string) Option {
+	return OptionFunc(func(o *options) {
+		o.orderingKey = key
+	})
+}
+
+// WithMaxRetry 消息处理失败后的最大重试次数. 默认为 DefaultMaxRetry
+// 发布消息时生效于该条消息, 注册订阅者时生效于该订阅者. 两者同时设置时取较小值
+func WithMaxRetry(maxRetry int) Option {
+	return OptionFunc(func(o *options) {
+		if maxRetry < 0 {
+			maxRetry = 0
+		}
+		o.maxRetry = maxRetry
+	})
+}
+
+// WithRetryBackoff 消息处理失败后到下一次重试的间隔. 默认为 DefaultRetryBackoff. 注册订阅者时生效
+func WithRetryBackoff(backoff RetryBackoff) Option {
+	return OptionFunc(func(o *options) {
+		if backoff != nil {
+			o.retryBackoff = backoff
+		}
+	})
+}
+
+// WithDeadLetter 开启死信. 重试耗尽的消息会投递到 DeadLetterTopic(topic). 注册订阅者时生效
+func WithDeadLetter() Option {
+	return OptionFunc(func(o *options) {
+		o.deadLetter = true
+	})
+}
+
+// WithDeadLetterTopic 开启死信并指定死信主题. 注册订阅者时生效
+func WithDeadLetterTopic(topic string) Option {
+	return OptionFunc(func(o *options) {
+		o.deadLetter = true
+		o.deadLetterTopic = topic
+	})
+}
+
+// WithCodec 指定消息的编解码器. 默认为 BinaryCodec
+// 创建队列时生效于整个队列, 发布消息时用于编码消息, 注册订阅者时用于 CreateSubscriber 解码消息
+func WithCodec(codec Codec) Option {
+	return OptionFunc(func(o *options) {
+		if codec != nil {
+			o.codec = codec
+		}
+	})
+}
+
+// WithMetadata 为消息附加元数据. 订阅者可以通过 MetadataFromContext 获取. 发布消息时生效
+func WithMetadata(key, value string) Option {
+	return OptionFunc(func(o *options) {
+		o.metadata[key] = value
+	})
+}
+
+// withMetadata 使用 metadata 作为消息的元数据
+func withMetadata(metadata Metadata) Option {
+	return OptionFunc(func(o *options) {
+		for key, value := range metadata {
+			o.metadata[key] = value
+		}
+	})
+}
+
+// WithSubscriberMiddlewares 使用中间件包装订阅者. 注册订阅者时生效
+// 创建队列时传入则作用于该队列所有的订阅者, 并且位于注册订阅者时传入的中间件的外层
+func WithSubscriberMiddlewares(middlewares ...SubscriberMiddleware) Option {
+	return OptionFunc(func(o *options) {
+		o.middlewares = append(o.middlewares, middlewares...)
+	})
+}
+
+// WithMessageID 指定消息的ID. 未指定时自动生成. 订阅者可以通过 MessageIDFromContext 获取. 发布消息时生效
+func WithMessageID(id string) Option {
+	return OptionFunc(func(o *options) {
+		o.messageID = id
+	})
+}
+
+// WithPriority 指定消息的优先级. 消息会投递到优先级对应的队列中. 发布消息时生效, 仅 redis 队列支持
+func WithPriority(priority Priority) Option {
+	return WithQueueName(string(priority))
+}
+
+// WithQueueName 指定消息投递的队列名称. 队列需要在 RedisQueueConfig.Queues 中配置. 发布消息时生效, 仅 redis 队列支持
+func WithQueueName(name string) Option {
+	return OptionFunc(func(o *options) {
+		o.queueName = name
+	})
+}
+
+// WithGroup 指定订阅者的消费组. 注册订阅者时生效
+// 同一个主题的不同消费组各自收到每一条消息的副本, 相同消费组的订阅者(通常是同一个服务的多个副本)竞争消费.
+// 在同一个队列中使用相同的主题以及消费组重复注册时, 后注册的订阅者会替换之前的订阅者
+func WithGroup(group string) Option {
+	return OptionFunc(func(o *options) {
+		o.group = group
+	})
+}
+
+// WithConcurrency 限制订阅者同时处理消息的数量, 避免单个主题占满所有的处理协程.
+// 达到限制时消息会延迟后再次投递, 不计入重试次数. 注册订阅者时生效
+func WithConcurrency(concurrency int) Option {
+	return OptionFunc(func(o *options) {
+		o.concurrency = concurrency
+	})
+}
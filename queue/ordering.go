@@ -0,0 +1,191 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// orderingKeyPrefix redis 队列保存顺序键状态的 key 的前缀
+	orderingKeyPrefix = "queue:ordering:"
+	// orderingKeyTTL 顺序键状态的有效期. 每次发布以及处理消息时刷新
+	orderingKeyTTL = 7 * 24 * time.Hour
+	// orderingRetryDelay 顺序键中更早的消息尚未完成时, 消息再次尝试投递的间隔
+	orderingRetryDelay = time.Second
+	// orderingFieldCreated 顺序键状态的 hash 中记录顺序键创建时间的字段
+	orderingFieldCreated = "created"
+)
+
+// errOrderingWait 顺序键中更早的消息尚未完成时订阅方返回的错误. 消息会延迟后再次投递并且不计入重试次数
+var errOrderingWait = errors.New("waiting for earlier message with the same ordering key")
+
+var (
+	// orderingNextScript 分配序号. 顺序键第一次发布消息时记录 redis 服务端的时间, 用于判断订阅者是否早于顺序键注册
+	orderingNextScript = redis.NewScript(`
+local seq = redis.call('HINCRBY', KEYS[1], 'seq', 1)
+if seq == 1 then
+	local now = redis.call('TIME')
+	redis.call('HSET', KEYS[1], 'created', now[1] .. string.format('%06d', now[2]))
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+return seq
+`)
+	// orderingRegisterScript 记录订阅者第一次注册时 redis 服务端的时间, 返回记录的时间
+	orderingRegisterScript = redis.NewScript(`
+local now = redis.call('TIME')
+redis.call('SETNX', KEYS[1], now[1] .. string.format('%06d', now[2]))
+return redis.call('GET', KEYS[1])
+`)
+)
+
+type (
+	// orderingState 消息在顺序键中的状态
+	orderingState int
+
+	// redisOrdering 基于 redis 的顺序键. 发布时为每条消息分配递增的序号,
+	// 每个订阅者记录已经完成的最大序号, 只处理序号紧随其后的消息.
+	// 顺序键的序号以及所有订阅者的状态保存在同一个 hash 中, 一起刷新有效期.
+	// 订阅者注册之后创建的顺序键从第一个序号开始; 注册之前已经存在的顺序键(新增的消费组或者修改了主题模式)
+	// 从订阅者收到的第一条消息开始, 更早的消息不保证顺序
+	redisOrdering struct {
+		client redis.UniversalClient
+	}
+)
+
+const (
+	// orderingReady 消息可以被处理
+	orderingReady orderingState = iota
+	// orderingWait 顺序键中更早的消息尚未完成
+	orderingWait
+	// orderingDone 消息已经被处理过. 重复投递的消息直接丢弃
+	orderingDone
+	// orderingLate 消息的序号已经被跳过, 例如重试耗尽后被归档的消息重新投递, 或者早于订阅者开始处理顺序键时收到的第一条消息.
+	// 消息不保证顺序直接处理
+	orderingLate
+)
+
+// register 记录订阅者的注册时间. 重复注册时保留第一次的时间
+func (o *redisOrdering) register(ctx context.Context, subscription string) (int64, error) {
+	return orderingRegisterScript.Run(ctx, o.client, []string{o.registeredKey(subscription)}).Int64()
+}
+
+// next 为发布的消息分配序号
+func (o *redisOrdering) next(ctx context.Context, topic, key string) (int64, error) {
+	return orderingNextScript.Run(ctx, o.client, []string{o.key(topic, key)}, orderingKeyTTL.Milliseconds()).Int64()
+}
+
+// skip 跳过不会被投递的序号. subscription 为空时对所有订阅者生效, 用于发布失败的消息;
+// 否则只对该订阅者生效, 用于被删除或者被归档的消息副本
+func (o *redisOrdering) skip(ctx context.Context, subscription, topic string, envelope *envelope) error {
+	return o.set(ctx, topic, envelope.OrderingKey, o.skipField(subscription, envelope.Sequence), 1)
+}
+
+// state 返回消息在订阅者中的状态. 紧随其后的序号被跳过时推进已经完成的序号
+func (o *redisOrdering) state(ctx context.Context, subscription, topic string, envelope *envelope) (orderingState, error) {
+	key := o.key(topic, envelope.OrderingKey)
+	for {
+		values, err := o.client.HMGet(ctx, key, o.doneField(subscription), o.baseField(subscription), orderingFieldCreated).Result()
+		if err != nil {
+			return orderingWait, err
+		}
+		if values[0] == nil {
+			if err := o.start(ctx, subscription, topic, envelope, parseInt64(values[2])); err != nil {
+				return orderingWait, err
+			}
+			continue
+		}
+		done, base := parseInt64(values[0]), parseInt64(values[1])
+		switch {
+		case envelope.Sequence <= base:
+			return orderingLate, nil
+		case envelope.Sequence <= done:
+			skipped, err := o.skipped(ctx, subscription, topic, envelope.OrderingKey, envelope.Sequence)
+			if err != nil || !skipped {
+				return orderingDone, err
+			}
+			return orderingLate, nil
+		case envelope.Sequence == done+1:
+			return orderingReady, nil
+		}
+		skipped, err := o.skipped(ctx, subscription, topic, envelope.OrderingKey, done+1)
+		if err != nil || !skipped {
+			return orderingWait, err
+		}
+		if err := o.complete(ctx, subscription, topic, envelope.OrderingKey, done+1); err != nil {
+			return orderingWait, err
+		}
+	}
+}
+
+// start 订阅者第一次处理顺序键. 顺序键在订阅者注册之后创建时从第一个序号开始,
+// 否则之前的消息可能不会投递给该订阅者, 将收到的第一条消息之前的序号视为已经完成
+func (o *redisOrdering) start(ctx context.Context, subscription, topic string, envelope *envelope, created int64) error {
+	registered, err := o.register(ctx, subscription)
+	if err != nil {
+		return err
+	}
+	var base int64
+	if created < registered {
+		base = envelope.Sequence - 1
+	}
+	key := o.key(topic, envelope.OrderingKey)
+	pipe := o.client.TxPipeline()
+	pipe.HSetNX(ctx, key, o.doneField(subscription), base)
+	pipe.HSetNX(ctx, key, o.baseField(subscription), base)
+	pipe.Expire(ctx, key, orderingKeyTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// skipped 返回序号是否被跳过
+func (o *redisOrdering) skipped(ctx context.Context, subscription, topic, key string, seq int64) (bool, error) {
+	values, err := o.client.HMGet(ctx, o.key(topic, key), o.skipField("", seq), o.skipField(subscription, seq)).Result()
+	if err != nil {
+		return false, err
+	}
+	return values[0] != nil || values[1] != nil, nil
+}
+
+// complete 记录订阅者已经完成的序号
+func (o *redisOrdering) complete(ctx context.Context, subscription, topic, key string, seq int64) error {
+	return o.set(ctx, topic, key, o.doneField(subscription), seq)
+}
+
+func (o *redisOrdering) set(ctx context.Context, topic, key, field string, value int64) error {
+	pipe := o.client.TxPipeline()
+	pipe.HSet(ctx, o.key(topic, key), field, value)
+	pipe.Expire(ctx, o.key(topic, key), orderingKeyTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (o *redisOrdering) key(topic, key string) string {
+	return orderingKeyPrefix + "key:" + topic + ":" + key
+}
+
+func (o *redisOrdering) registeredKey(subscription string) string {
+	return orderingKeyPrefix + "registered:" + subscription
+}
+
+func (o *redisOrdering) doneField(subscription string) string {
+	return "done:" + subscription
+}
+
+func (o *redisOrdering) baseField(subscription string) string {
+	return "base:" + subscription
+}
+
+func (o *redisOrdering) skipField(subscription string, seq int64) string {
+	return "skip:" + subscription + ":" + strconv.FormatInt(seq, 10)
+}
+
+// parseInt64 解析 HMGET 返回的整数. 字段不存在时返回 0
+func parseInt64(value any) int64 {
+	s, _ := value.(string)
+	result, _ := strconv.ParseInt(s, 10, 64)
+	return result
+}
@@ -0,0 +1,109 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bmizerany/assert"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisOrdering(t *testing.T) (*redisOrdering, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return &redisOrdering{client: client}, server
+}
+
+// registerOrdered 在 redis 服务端的时间 at 注册订阅者, 之后发布的消息在 at 的一秒之后创建顺序键
+func registerOrdered(t *testing.T, ordering *redisOrdering, server *miniredis.Miniredis, at time.Time, subscriptions ...string) {
+	t.Helper()
+	server.SetTime(at)
+	for _, subscription := range subscriptions {
+		_, err := ordering.register(context.Background(), subscription)
+		assert.Equal(t, nil, err)
+	}
+	server.SetTime(at.Add(time.Second))
+}
+
+func publishOrdered(t *testing.T, ordering *redisOrdering, topic, key string) *envelope {
+	t.Helper()
+	seq, err := ordering.next(context.Background(), topic, key)
+	assert.Equal(t, nil, err)
+	return &envelope{OrderingKey: key, Sequence: seq}
+}
+
+func assertOrderingState(t *testing.T, ordering *redisOrdering, subscription string, envelope *envelope, want orderingState) {
+	t.Helper()
+	state, err := ordering.state(context.Background(), subscription, "order", envelope)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, want, state, envelope.Sequence)
+}
+
+func TestRedisOrdering(t *testing.T) {
+	ordering, server := newTestRedisOrdering(t)
+	registerOrdered(t, ordering, server, time.Now(), "order")
+	ctx := context.Background()
+	first := publishOrdered(t, ordering, "order", "order-1")
+	second := publishOrdered(t, ordering, "order", "order-1")
+
+	// 新的顺序键从第一个序号开始, 先收到的后一条消息需要等待
+	assertOrderingState(t, ordering, "order", second, orderingWait)
+	assertOrderingState(t, ordering, "order", first, orderingReady)
+	assertOrderingState(t, ordering, "order", second, orderingWait)
+	assert.Equal(t, nil, ordering.complete(ctx, "order", "order", "order-1", first.Sequence))
+	assertOrderingState(t, ordering, "order", first, orderingDone)
+	assertOrderingState(t, ordering, "order", second, orderingReady)
+}
+
+func TestRedisOrdering_NewSubscription(t *testing.T) {
+	ordering, server := newTestRedisOrdering(t)
+	now := time.Now()
+	registerOrdered(t, ordering, server, now, "order@billing")
+	ctx := context.Background()
+	first := publishOrdered(t, ordering, "order", "order-1")
+	assertOrderingState(t, ordering, "order@billing", first, orderingReady)
+	assert.Equal(t, nil, ordering.complete(ctx, "order@billing", "order", "order-1", first.Sequence))
+
+	// 顺序键创建之后新增的消费组从收到的第一条消息开始, 更早的消息不保证顺序
+	registerOrdered(t, ordering, server, now.Add(time.Minute), "order@shipping")
+	second := publishOrdered(t, ordering, "order", "order-1")
+	third := publishOrdered(t, ordering, "order", "order-1")
+	assertOrderingState(t, ordering, "order@shipping", third, orderingReady)
+	assertOrderingState(t, ordering, "order@shipping", second, orderingLate)
+	assert.Equal(t, nil, ordering.complete(ctx, "order@shipping", "order", "order-1", third.Sequence))
+	assertOrderingState(t, ordering, "order@shipping", third, orderingDone)
+	assertOrderingState(t, ordering, "order@billing", third, orderingWait)
+
+	// 顺序键的状态过期之后重新从第一个序号开始
+	server.Del(ordering.key("order", "order-1"))
+	first = publishOrdered(t, ordering, "order", "order-1")
+	second = publishOrdered(t, ordering, "order", "order-1")
+	assertOrderingState(t, ordering, "order@shipping", second, orderingWait)
+	assertOrderingState(t, ordering, "order@shipping", first, orderingReady)
+}
+
+func TestRedisOrdering_Skip(t *testing.T) {
+	ordering, server := newTestRedisOrdering(t)
+	registerOrdered(t, ordering, server, time.Now(), "order")
+	ctx := context.Background()
+	first := publishOrdered(t, ordering, "order", "order-1")
+	second := publishOrdered(t, ordering, "order", "order-1")
+	third := publishOrdered(t, ordering, "order", "order-1")
+
+	assertOrderingState(t, ordering, "order", first, orderingReady)
+	assertOrderingState(t, ordering, "order", third, orderingWait)
+	// 重试耗尽后被归档的消息跳过其序号, 重新投递时不保证顺序
+	assert.Equal(t, nil, ordering.skip(ctx, "order", "order", first))
+	assertOrderingState(t, ordering, "order", second, orderingReady)
+	assertOrderingState(t, ordering, "order", first, orderingLate)
+
+	// 发布失败的消息对所有订阅者跳过
+	assert.Equal(t, nil, ordering.skip(ctx, "", "order", second))
+	assertOrderingState(t, ordering, "order", third, orderingReady)
+}
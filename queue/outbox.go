@@ -0,0 +1,255 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/chaihaobo/gocommon/logger"
+	"github.com/chaihaobo/gocommon/trace"
+)
+
+const (
+	DefaultOutboxRelayInterval  = time.Second
+	DefaultOutboxRelayBatchSize = 100
+)
+
+const (
+	OutboxStatusPending OutboxStatus = "pending"
+	OutboxStatusSent    OutboxStatus = "sent"
+)
+
+type (
+	// OutboxStatus 发件箱消息的状态
+	OutboxStatus string
+
+	// OutboxMessage 发件箱表中的消息
+	OutboxMessage struct {
+		ID        uint64   `gorm:"primaryKey;autoIncrement"`
+		MessageID string   `gorm:"size:64;not null;uniqueIndex"`
+		Topic     string   `gorm:"size:255;not null"`
+		Payload   []byte   `gorm:"type:mediumblob;not null"`
+		Metadata  Metadata `gorm:"type:text;serializer:json"`
+		QueueName string   `gorm:"size:64"`
+		MaxRetry  int
+		// AvailableAt 消息可被消费的时间. 转发时作为 WithProcessAt 传递给队列
+		AvailableAt time.Time    `gorm:"not null"`
+		Status      OutboxStatus `gorm:"size:16;not null;index:idx_queue_outbox_relay,priority:1"`
+		// Attempts 转发的次数
+		Attempts  int
+		LastError string `gorm:"type:text"`
+		// NextRelayAt 下一次转发的时间. 转发失败后按照 OutboxRelayConfig.RetryBackoff 推迟
+		NextRelayAt time.Time `gorm:"not null;index:idx_queue_outbox_relay,priority:2"`
+		CreatedAt   time.Time
+		SentAt      *time.Time
+	}
+
+	// Outbox 事务性发件箱. 消息与业务数据在同一个数据库事务中写入发件箱表, 再由 OutboxRelay 转发到队列,
+	// 避免业务数据提交后进程退出导致消息丢失
+	Outbox struct {
+		db      *gorm.DB
+		options []Option
+	}
+
+	// OutboxRelayConfig 发件箱转发配置
+	OutboxRelayConfig struct {
+		// Interval 轮询发件箱的间隔. 默认为 DefaultOutboxRelayInterval
+		Interval time.Duration
+		// BatchSize 每次转发的最大消息数量. 默认为 DefaultOutboxRelayBatchSize
+		BatchSize int
+		// RetryBackoff 转发失败后到下一次转发的间隔. 默认为 DefaultRetryBackoff
+		RetryBackoff RetryBackoff
+	}
+
+	// OutboxRelay 将发件箱中待发送的消息转发到队列.
+	// 转发时通过 SELECT ... FOR UPDATE SKIP LOCKED 锁定消息, 多个副本可以同时运行.
+	// 消息发布成功但标记已发送失败时消息会被再次转发, 订阅者可以配合 IdempotentMiddleware 按照消息ID去重
+	OutboxRelay struct {
+		outbox *Outbox
+		queue  Queue
+		logger logger.Logger
+		config OutboxRelayConfig
+
+		mu     sync.Mutex
+		cancel context.CancelFunc
+		done   chan struct{}
+	}
+)
+
+func (OutboxMessage) TableName() string {
+	return "queue_outbox"
+}
+
+// AutoMigrate 创建或者更新发件箱表
+func (o *Outbox) AutoMigrate(ctx context.Context) error {
+	return o.db.WithContext(ctx).AutoMigrate(&OutboxMessage{})
+}
+
+// Publish 在事务 tx 中将消息写入发件箱. 事务提交后消息才会被转发, 事务回滚时消息随之丢弃.
+// 支持 WithCodec, WithMessageID, WithMetadata, WithDelay, WithProcessAt, WithMaxRetry 以及 WithQueueName
+func (o *Outbox) Publish(ctx context.Context, tx *gorm.DB, topic string, message any, opts ...Option) error {
+	options := newOptions(mergeOptions(o.options, opts))
+	payload, err := options.codec.Marshal(message)
+	if err != nil {
+		return err
+	}
+	messageID := options.messageID
+	if messageID == "" {
+		messageID = uuid.NewString()
+	}
+	metadata := options.metadata.clone()
+	trace.Propagator.Inject(ctx, metadata)
+	now := time.Now()
+	return tx.WithContext(ctx).Create(&OutboxMessage{
+		MessageID:   messageID,
+		Topic:       topic,
+		Payload:     payload,
+		Metadata:    metadata,
+		QueueName:   options.queueName,
+		MaxRetry:    options.maxRetry,
+		AvailableAt: options.availableAt(),
+		Status:      OutboxStatusPending,
+		NextRelayAt: now,
+		CreatedAt:   now,
+	}).Error
+}
+
+// Purge 删除 before 之前已发送的消息, 返回删除的数量
+func (o *Outbox) Purge(ctx context.Context, before time.Time) (int64, error) {
+	result := o.db.WithContext(ctx).
+		Where("status = ? AND sent_at < ?", OutboxStatusSent, before).
+		Delete(&OutboxMessage{})
+	return result.RowsAffected, result.Error
+}
+
+// Start 异步启动转发. 重复调用不会启动多个转发协程
+func (r *OutboxRelay) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go r.run(ctx, r.done)
+}
+
+// Stop 停止转发. 等待正在进行的转发完成
+func (r *OutboxRelay) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+	r.cancel = nil
+}
+
+func (r *OutboxRelay) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+	for {
+		// 一次转发满一批时说明还有待转发的消息, 立即进行下一次转发
+		for ctx.Err() == nil {
+			relayed, err := r.Relay(ctx)
+			if err != nil {
+				r.logger.Error(ctx, "failed to relay outbox messages", err)
+				break
+			}
+			if relayed < r.config.BatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Relay 转发一批待发送的消息, 返回转发成功的数量. 通常由 Start 周期性地调用
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	relayed := 0
+	err := r.outbox.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var messages []*OutboxMessage
+		if err := tx.Clauses(clause.Locking{
+			Strength: clause.LockingStrengthUpdate,
+			Options:  clause.LockingOptionsSkipLocked,
+		}).Where("status = ? AND next_relay_at <= ?", OutboxStatusPending, time.Now()).
+			Order("id").Limit(r.config.BatchSize).Find(&messages).Error; err != nil {
+			return err
+		}
+		for _, message := range messages {
+			attempts := message.Attempts + 1
+			updates := map[string]any{"attempts": attempts}
+			if err := r.publish(ctx, message); err != nil {
+				r.logger.Error(ctx, "failed to relay outbox message", err,
+					zap.String("topic", message.Topic), zap.String("message_id", message.MessageID), zap.Int("attempts", attempts))
+				updates["last_error"] = err.Error()
+				updates["next_relay_at"] = time.Now().Add(r.config.RetryBackoff(attempts, err))
+			} else {
+				updates["status"] = OutboxStatusSent
+				updates["sent_at"] = time.Now()
+				relayed++
+			}
+			if err := tx.Model(message).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return relayed, err
+}
+
+func (r *OutboxRelay) publish(ctx context.Context, message *OutboxMessage) error {
+	// 恢复写入发件箱时的链路, 使订阅方的链路与写入方关联
+	ctx = trace.Propagator.Extract(ctx, message.Metadata)
+	opts := []Option{
+		WithCodec(BinaryCodec),
+		WithMessageID(message.MessageID),
+		withMetadata(message.Metadata),
+		WithProcessAt(message.AvailableAt),
+		WithMaxRetry(message.MaxRetry),
+	}
+	if message.QueueName != "" {
+		opts = append(opts, WithQueueName(message.QueueName))
+	}
+	return r.queue.Publish(ctx, message.Topic, message.Payload, opts...)
+}
+
+// NewOutbox 创建事务性发件箱. db 通常由 mysql.GormDB 创建
+// opts 会作为所有写入发件箱的消息的默认选项
+func NewOutbox(db *gorm.DB, opts ...Option) *Outbox {
+	return &Outbox{
+		db:      db,
+		options: opts,
+	}
+}
+
+// NewOutboxRelay 创建发件箱转发器. 消息会被转发到 queue 中
+func NewOutboxRelay(outbox *Outbox, queue Queue, logger logger.Logger, config OutboxRelayConfig) *OutboxRelay {
+	if config.Interval <= 0 {
+		config.Interval = DefaultOutboxRelayInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultOutboxRelayBatchSize
+	}
+	if config.RetryBackoff == nil {
+		config.RetryBackoff = DefaultRetryBackoff
+	}
+	return &OutboxRelay{
+		outbox: outbox,
+		queue:  queue,
+		logger: logger,
+		config: config,
+	}
+}
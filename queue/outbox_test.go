@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bmizerany/assert"
+	gormMysqlDriver "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+func newMockGormDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	gormDB, err := gorm.Open(gormMysqlDriver.New(gormMysqlDriver.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.Equal(t, nil, err)
+	return gormDB, mock
+}
+
+func TestOutbox_Publish(t *testing.T) {
+	db, mock := newMockGormDB(t)
+	outbox := NewOutbox(db, WithCodec(JSONCodec))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `queue_outbox`")).
+		WithArgs("order-1", "order.created", []byte(`{"id":1,"status":"created"}`), sqlmock.AnyArg(), "", DefaultMaxRetry,
+			sqlmock.AnyArg(), OutboxStatusPending, 0, "", sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return outbox.Publish(context.Background(), tx, "order.created",
+			&orderCreated{ID: 1, Status: "created"}, WithMessageID("order-1"))
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
+
+func TestOutboxRelay_Relay(t *testing.T) {
+	db, mock := newMockGormDB(t)
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1)
+	received := make(chan string, 1)
+	queue.SubscribeTo("order.created", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		received <- MessageIDFromContext(ctx) + ":" + MetadataFromContext(ctx).Get("tenant") + ":" + string(message)
+		return nil
+	}))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+	relay := NewOutboxRelay(NewOutbox(db), queue, logger.NewNoopLogger(), OutboxRelayConfig{BatchSize: 10})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `queue_outbox` WHERE status = ? AND next_relay_at <= ? ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED")).
+		WithArgs(OutboxStatusPending, sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "message_id", "topic", "payload", "metadata", "queue_name",
+			"max_retry", "available_at", "status", "attempts", "next_relay_at"}).
+			AddRow(1, "order-1", "order.created", []byte("foo"), `{"tenant":"acme"}`, "",
+				3, time.Now(), OutboxStatusPending, 0, time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `queue_outbox` SET `attempts`=?,`sent_at`=?,`status`=? WHERE `id` = ?")).
+		WithArgs(1, sqlmock.AnyArg(), OutboxStatusSent, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	relayed, err := relay.Relay(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, relayed)
+	assert.Equal(t, "order-1:acme:foo", waitMessage(t, received))
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
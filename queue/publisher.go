@@ -0,0 +1,32 @@
+package queue
+
+import "context"
+
+// Publisher 类型化的发布者. 绑定了主题以及消息类型, 通过 WithCodec 可以指定消息的编解码方式
+//
+//	publisher := queue.NewPublisher[*OrderCreated](q, "order.created", queue.WithCodec(queue.JSONCodec))
+//	err := publisher.Publish(ctx, &OrderCreated{ID: 1})
+type Publisher[T any] struct {
+	queue Queue
+	topic string
+	opts  []Option
+}
+
+// NewPublisher 创建类型化的发布者. opts 会作为每次发布的默认选项
+func NewPublisher[T any](queue Queue, topic string, opts ...Option) *Publisher[T] {
+	return &Publisher[T]{
+		queue: queue,
+		topic: topic,
+		opts:  opts,
+	}
+}
+
+// Topic 返回发布者绑定的主题
+func (p *Publisher[T]) Topic() string {
+	return p.topic
+}
+
+// Publish 发布消息到绑定的主题中
+func (p *Publisher[T]) Publish(ctx context.Context, message T, opts ...Option) error {
+	return p.queue.Publish(ctx, p.topic, message, append(p.opts[:len(p.opts):len(p.opts)], opts...)...)
+}
@@ -2,26 +2,43 @@ package queue
 
 import (
 	"context"
-	"encoding"
 	"fmt"
+
 	"github.com/chaihaobo/gocommon/trace"
 	"go.opentelemetry.io/otel"
-	"reflect"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 type (
 	Queue interface {
 		// Publish 发布消息到topic中
 		Publish(ctx context.Context, topic string, message any, opts ...Option) error
-		// SubscribeTo 注册订阅者到主题中
-		SubscribeTo(topic string, subscriber Subscriber)
+		// PublishBatch 批量发布消息到topic中. 返回每条消息的发布结果, 任意一条消息发布失败时返回错误
+		// opts 作用于所有的消息, 因此不应当使用 WithMessageID
+		PublishBatch(ctx context.Context, topic string, messages []any, opts ...Option) ([]PublishResult, error)
+		// SubscribeTo 注册订阅者到主题中. topic 支持通配符: * 匹配一个层级, 位于最后的 > 匹配剩余的一个或者多个层级.
+		// 通配符不匹配以 .dlq 结尾的死信主题, 除非模式本身以 .dlq 结尾.
+		// 一条消息匹配到多个订阅者时(不同的主题模式或者 WithGroup 指定的不同消费组), 每个订阅者收到一个独立的副本
+		SubscribeTo(topic string, subscriber Subscriber, opts ...Option)
 		// StartSubscriber 异步启动订阅. 开始监听消息
 		StartSubscriber() error
 		// RunSubscriber 同步启动订阅. 开始监听消息
 		RunSubscriber() error
-		// Shutdown 停止订阅. 停止监听消息
-		Shutdown()
+		// Shutdown 停止订阅. 停止拉取新的消息并等待正在处理的消息完成.
+		// ctx 结束时取消正在处理的消息的 context, 等待订阅者返回后返回 *ShutdownError
+		Shutdown(ctx context.Context) error
+		// Close 释放发布消息使用的连接. 调用后不能再发布消息
+		Close() error
+	}
+
+	// ShutdownError 停止订阅时等待超时返回的错误
+	ShutdownError struct {
+		// Requeued 因超时被取消处理的消息数量.
+		// redis 队列会将这些消息重新入队并且不计入重试次数, 内存队列会与其他未处理的消息一起丢弃
+		Requeued int
+		Err      error
 	}
+
 	Subscriber interface {
 		Subscribe(ctx context.Context, topic string, message []byte) error
 	}
@@ -29,34 +46,30 @@ type (
 	SubscriberFunc func(ctx context.Context, topic string, message []byte) error
 )
 
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("queue shutdown: %d in-flight messages requeued: %v", e.Requeued, e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
 func (s SubscriberFunc) Subscribe(ctx context.Context, topic string, message []byte) error {
 	return s(ctx, topic, message)
 }
 
 // CreateSubscriber 通过处理函数返回订阅者接口
-// T 必须为[]byte 或者 实现了encoding.BinaryUnmarshaler接口的指针类型
+// 消息使用注册订阅者时指定的 Codec 解码, 默认为 BinaryCodec: T 必须为[]byte 或者 实现了encoding.BinaryUnmarshaler接口的指针类型
 func CreateSubscriber[T any](handleFunc func(ctx context.Context, topic string, message T) error) Subscriber {
 	return SubscriberFunc(func(ctx context.Context, topic string, payload []byte) error {
-		ctx, span := otel.Tracer(trace.DefaultTracerName).Start(ctx, "queue.subscribe.consume."+topic)
+		ctx, span := otel.Tracer(trace.DefaultTracerName).Start(ctx, "queue.subscribe.consume."+topic,
+			oteltrace.WithSpanKind(oteltrace.SpanKindConsumer))
 		defer span.End()
 
-		var handleFuncMsgArg T
-		handleFuncType := reflect.TypeOf(handleFunc)
-		messageType := handleFuncType.In(2)
-		if messageType.Implements(reflect.TypeOf(new(encoding.BinaryUnmarshaler)).Elem()) &&
-			messageType.Kind() == reflect.Ptr {
-			result := reflect.New(messageType.Elem()).Interface().(encoding.BinaryUnmarshaler)
-			if err := result.UnmarshalBinary(payload); err != nil {
-				return err
-			}
-			handleFuncMsgArg = result.(T)
-		}
-		if messageType.Kind() == reflect.Slice && messageType.Elem().Kind() == reflect.Uint8 {
-			handleFuncMsgArg = reflect.ValueOf(payload).Interface().(T)
-		}
-		if reflect.ValueOf(handleFuncMsgArg).IsZero() {
-			return fmt.Errorf("message type not supported")
+		message, err := decodeMessage[T](CodecFromContext(ctx), payload)
+		if err != nil {
+			return err
 		}
-		return handleFunc(ctx, topic, handleFuncMsgArg)
+		return handleFunc(ctx, topic, message)
 	})
 }
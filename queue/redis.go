@@ -2,114 +2,386 @@ package queue
 
 import (
 	"context"
-	"encoding"
+	"errors"
 	"fmt"
-	"reflect"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/chaihaobo/gocommon/logger"
 
 	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
+// asynqDeadlineGrace asynq 在消息的截止时间之后额外等待的时间, 用于订阅方处理超时的消息
+const asynqDeadlineGrace = 30 * time.Second
+
 type RedisQueue struct {
-	logger        logger.Logger
-	asynqServer   *asynq.Server
-	asynqClient   *asynq.Client
-	asynqServeMux *asynq.ServeMux
+	logger          logger.Logger
+	asynqServer     *asynq.Server
+	asynqClient     *asynq.Client
+	router          *router
+	options         []Option
+	shutdownTimeout time.Duration
+	redisClient     redis.UniversalClient
+	pausedTopics    *pausedTopics
+	// unique WithUnique 的去重存储
+	unique   DedupStore
+	ordering *redisOrdering
+	replies  *redisReplies
+
+	// baseCtx 所有消息处理的 context 的父 context. 停止订阅超时时取消
+	baseCtx    context.Context
+	cancelBase context.CancelFunc
+	inflight   inflight
+	aborted    atomic.Bool
+	requeued   atomic.Int64
 }
 
 func (r *RedisQueue) Publish(ctx context.Context, topic string, message any, opts ...Option) error {
-	data, err := r.parseMessageToBytes(message)
+	_, err := r.publish(ctx, topic, message, newOptions(mergeOptions(r.options, opts)))
+	return err
+}
+
+// PublishBatch 批量发布消息. 消息由 defaultBatchPublishConcurrency 个协程并发地逐条发布, 每条消息单独写入 redis,
+// 不使用 pipeline, 不保证消息之间的顺序. 指定 WithOrderingKey 时按照顺序依次写入
+func (r *RedisQueue) PublishBatch(ctx context.Context, topic string, messages []any, opts ...Option) ([]PublishResult, error) {
+	options := newOptions(mergeOptions(r.options, opts))
+	concurrency := defaultBatchPublishConcurrency
+	if options.orderingKey != "" {
+		concurrency = 1
+	}
+	return publishBatch(ctx, messages, concurrency, func(ctx context.Context, message any) (string, error) {
+		return r.publish(ctx, topic, message, options)
+	})
+}
+
+func (r *RedisQueue) publish(ctx context.Context, topic string, message any, options *options) (string, error) {
+	ctx, span := startPublishSpan(ctx, topic)
+	defer span.End()
+
+	envelope, err := newEnvelope(ctx, options, message)
 	if err != nil {
-		return err
+		return "", err
 	}
-	asynqOptions := r.mappingAsynqOptions(opts)
-	taskInfo, err := r.asynqClient.EnqueueContext(ctx, asynq.NewTask(topic, data), asynqOptions...)
+	if strings.HasPrefix(topic, ReplyTopicPrefix) {
+		return r.publishReply(ctx, topic, envelope)
+	}
+	release, err := acquireUnique(ctx, r.unique, topic, options)
 	if err != nil {
-		return err
+		recordPublish(ctx, topic, err)
+		return "", err
+	}
+	if envelope.OrderingKey != "" {
+		if envelope.Sequence, err = r.ordering.next(ctx, topic, envelope.OrderingKey); err != nil {
+			release()
+			recordPublish(ctx, topic, err)
+			return "", err
+		}
+	}
+	data, err := encodeEnvelope(envelope)
+	var taskInfo *asynq.TaskInfo
+	if err == nil {
+		taskInfo, err = r.asynqClient.EnqueueContext(ctx, asynq.NewTask(topic, data), r.mappingAsynqOptions(options)...)
+	}
+	recordPublish(ctx, topic, err)
+	if err != nil {
+		release()
+		if envelope.OrderingKey != "" {
+			// 跳过发布失败的消息的序号, 避免阻塞同一个顺序键之后的消息
+			if skipErr := r.ordering.skip(ctx, "", topic, envelope); skipErr != nil {
+				r.logger.Error(ctx, "failed to skip ordering sequence", skipErr,
+					zap.String("topic", topic), zap.String("ordering_key", envelope.OrderingKey))
+			}
+		}
+		return "", err
 	}
 	r.logger.Info(ctx, "published message to redis queue success",
-		zap.ByteString("payload", data), zap.String("id", taskInfo.ID))
-	return nil
+		zap.ByteString("payload", data), zap.String("id", taskInfo.ID), zap.String("message_id", envelope.ID))
+	return envelope.ID, nil
 }
 
-func (r *RedisQueue) SubscribeTo(topic string, subscriber Subscriber) {
-	r.asynqServeMux.HandleFunc(topic, func(ctx context.Context, task *asynq.Task) error {
-		topic := task.Type()
-		payload := task.Payload()
-		return subscriber.Subscribe(ctx, topic, payload)
-	})
+// SubscribeTo 注册订阅者. topic 支持通配符: * 匹配一个层级, 位于最后的 > 匹配剩余的一个或者多个层级.
+// 一条消息匹配到多个订阅者时, 每个订阅者收到一个独立的副本, 各自重试以及进入死信.
+// 多个进程共享同一个 redis 时, 每个进程都需要注册所有的订阅者, 否则消息可能被没有订阅者的进程拉取后重试
+func (r *RedisQueue) SubscribeTo(topic string, subscriber Subscriber, opts ...Option) {
+	subscription := newSubscription(subscriber, newOptions(mergeOptions(r.options, opts)))
+	r.router.add(topic, subscription)
+	// 记录订阅者的注册时间, 之后创建的顺序键从第一个序号开始. 失败时在第一次处理顺序消息时记录
+	if _, err := r.ordering.register(context.Background(), subscription.key); err != nil {
+		r.logger.Error(context.Background(), "failed to register ordering subscription", err,
+			zap.String("topic", topic), zap.String("subscription", subscription.key))
+	}
+}
+
+func (r *RedisQueue) StartSubscriber() error {
+	return r.asynqServer.Start(asynq.HandlerFunc(r.handle))
 }
 
-func (r *RedisQueue) parseMessageFromBytes(subscriberFunc SubscriberFunc, payload []byte) (any, error) {
-	var result any
-	subscribeFunc := reflect.TypeOf(subscriberFunc)
-	messageType := subscribeFunc.In(2)
+func (r *RedisQueue) handle(ctx context.Context, task *asynq.Task) error {
+	r.inflight.add()
+	defer r.inflight.done()
+
+	topic := task.Type()
+	if r.pausedTopics.contains(ctx, topic) {
+		return errTopicPaused
+	}
+	envelope := decodeEnvelope(task.Payload())
+	subscriptions := r.router.route(topic, envelope.Metadata.Get(MetadataSubscription))
+	switch len(subscriptions) {
+	case 0:
+		return fmt.Errorf("%w: %s", ErrNoSubscriber, topic)
+	case 1:
+		return r.consumeInOrder(ctx, subscriptions[0], topic, envelope, task.Payload())
+	default:
+		return r.fanOut(ctx, topic, envelope, subscriptions)
+	}
+}
 
-	if messageType.Implements(reflect.TypeOf(new(encoding.BinaryUnmarshaler)).Elem()) {
-		if messageType.Kind() == reflect.Ptr {
-			messageType = messageType.Elem()
+// consumeInOrder 处理带有顺序键的消息. 同一个顺序键中更早的消息尚未完成时延迟后再次投递
+func (r *RedisQueue) consumeInOrder(ctx context.Context, subscription *subscription, topic string, envelope *envelope, data []byte) error {
+	if envelope.OrderingKey == "" {
+		return r.consume(ctx, subscription, topic, data)
+	}
+	state, err := r.ordering.state(ctx, subscription.key, topic, envelope)
+	if err != nil {
+		return err
+	}
+	switch state {
+	case orderingWait:
+		return errOrderingWait
+	case orderingDone:
+		r.logger.Warn(ctx, "redis queue message already handled, message dropped",
+			zap.String("topic", topic), zap.String("message_id", envelope.ID), zap.String("ordering_key", envelope.OrderingKey))
+		return nil
+	case orderingLate:
+		r.logger.Warn(ctx, "redis queue message sequence already skipped, handled out of order",
+			zap.String("topic", topic), zap.String("message_id", envelope.ID), zap.String("ordering_key", envelope.OrderingKey))
+		return r.consume(ctx, subscription, topic, data)
+	}
+	err = r.consume(ctx, subscription, topic, data)
+	switch {
+	case err == nil:
+		if completeErr := r.ordering.complete(context.WithoutCancel(ctx), subscription.key, topic,
+			envelope.OrderingKey, envelope.Sequence); completeErr != nil {
+			r.logger.Error(ctx, "failed to complete ordering sequence", completeErr,
+				zap.String("topic", topic), zap.String("ordering_key", envelope.OrderingKey))
 		}
-		result = (reflect.New(messageType).Interface().(encoding.BinaryUnmarshaler)).UnmarshalBinary(payload)
-		return result, nil
+	case r.archived(ctx, err):
+		// 被归档的消息不再投递, 跳过其序号避免阻塞同一个顺序键之后的消息. 归档的消息重新投递时不保证顺序
+		if skipErr := r.ordering.skip(context.WithoutCancel(ctx), subscription.key, topic, envelope); skipErr != nil {
+			r.logger.Error(ctx, "failed to skip ordering sequence", skipErr,
+				zap.String("topic", topic), zap.String("ordering_key", envelope.OrderingKey))
+		}
+	}
+	return err
+}
+
+// archived 返回处理失败的消息是否会被 asynq 归档: 跳过重试, 或者计入重试次数并且重试次数已经耗尽
+func (r *RedisQueue) archived(ctx context.Context, err error) bool {
+	if errors.Is(err, asynq.SkipRetry) {
+		return true
 	}
-	if messageType.Kind() == reflect.Slice && messageType.Elem().Kind() == reflect.Uint8 {
-		result = payload
-		return result, nil
+	if !r.isFailure(err) {
+		return false
 	}
-	return nil, fmt.Errorf("unsupported message type: %T, only support []byte|encoding.BinaryUnmarshaler", messageType)
+	retried, _ := asynq.GetRetryCount(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+	return retried >= maxRetry
 }
 
-func (r *RedisQueue) StartSubscriber() error {
-	return r.asynqServer.Start(r.asynqServeMux)
+func (r *RedisQueue) consume(ctx context.Context, subscription *subscription, topic string, data []byte) error {
+	ctx, envelope, err := subscription.consume(ctx, topic, data)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, ErrMessageExpired) {
+		r.logger.Warn(ctx, "redis queue message expired, message dropped",
+			zap.String("topic", topic), zap.String("message_id", envelope.ID))
+		return nil
+	}
+	if r.aborted.Load() && ctx.Err() != nil {
+		// 停止订阅超时被取消的消息重新入队, 不进入重试以及死信的流程
+		r.requeued.Add(1)
+		return ctx.Err()
+	}
+	if errors.Is(err, errConcurrencyLimited) {
+		return err
+	}
+	retried, _ := asynq.GetRetryCount(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+	if !subscription.exhausted(retried, maxRetry, err) {
+		return err
+	}
+	if deadLetterTopic := subscription.deadLetterTopicOf(topic, err); deadLetterTopic != "" {
+		if dlqErr := publishDeadLetter(ctx, r, deadLetterTopic, topic, envelope, retried, err); dlqErr != nil {
+			r.logger.Error(ctx, "failed to publish message to dead letter topic", dlqErr,
+				zap.String("topic", topic), zap.String("dead_letter_topic", deadLetterTopic))
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %w", asynq.SkipRetry, err)
 }
 
+// fanOut 为每个订阅者生成一个消息副本重新入队. 副本使用确定的任务ID, 原消息重试时不会重复生成副本
+func (r *RedisQueue) fanOut(ctx context.Context, topic string, envelope *envelope, subscriptions []*subscription) error {
+	taskID, _ := asynq.GetTaskID(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+	queueName, _ := asynq.GetQueueName(ctx)
+	for _, subscription := range subscriptions {
+		metadata := envelope.Metadata.clone()
+		metadata.Set(MetadataSubscription, subscription.key)
+		copied := *envelope
+		copied.Metadata = metadata
+		data, err := encodeEnvelope(&copied)
+		if err != nil {
+			return err
+		}
+		_, err = r.asynqClient.EnqueueContext(ctx, asynq.NewTask(topic, data),
+			asynq.TaskID(taskID+":"+subscription.key), asynq.MaxRetry(maxRetry), asynq.Queue(queueName))
+		if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunSubscriber 同步启动订阅. 阻塞直到收到退出信号, 然后在 RedisQueueConfig.ShutdownTimeout 内停止订阅
 func (r *RedisQueue) RunSubscriber() error {
-	return r.asynqServer.Run(r.asynqServeMux)
+	if err := r.StartSubscriber(); err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	<-signals
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.shutdownTimeout)
+	defer cancel()
+	return r.Shutdown(ctx)
 }
 
-func (r *RedisQueue) Shutdown() {
+// Shutdown 停止订阅. 停止从 redis 拉取消息并等待正在处理的消息完成.
+// ctx 结束时取消正在处理的消息的 context, 被取消的消息会重新入队
+func (r *RedisQueue) Shutdown(ctx context.Context) error {
+	r.asynqServer.Stop()
+	var err error
+	select {
+	case <-r.inflight.idle():
+	case <-ctx.Done():
+		r.aborted.Store(true)
+		r.cancelBase()
+		<-r.inflight.idle()
+		err = &ShutdownError{Requeued: int(r.requeued.Load()), Err: ctx.Err()}
+	}
 	r.asynqServer.Shutdown()
+	r.cancelBase()
+	return err
+}
+
+// Close 关闭发布消息, 接收响应以及查询暂停的主题使用的 redis 连接. 应当在 Shutdown 之后调用
+func (r *RedisQueue) Close() error {
+	return errors.Join(r.replies.close(), r.asynqClient.Close(), r.redisClient.Close())
 }
 
-func (r *RedisQueue) mappingAsynqOptions(opts []Option) []asynq.Option {
-	options := &options{}
-	for _, opt := range opts {
-		opt.apply(options)
+func (r *RedisQueue) mappingAsynqOptions(options *options) []asynq.Option {
+	asynqOpts := []asynq.Option{asynq.MaxRetry(options.maxRetry)}
+	if options.queueName != "" {
+		asynqOpts = append(asynqOpts, asynq.Queue(options.queueName))
+	}
+	if availableAt := options.availableAt(); availableAt.After(time.Now()) {
+		asynqOpts = append(asynqOpts, asynq.ProcessAt(availableAt))
+	}
+	// 消息的截止时间由订阅方处理, asynq 额外等待 asynqDeadlineGrace, 超时的消息可以重试以及投递到死信主题
+	if !options.deadline.IsZero() {
+		asynqOpts = append(asynqOpts, asynq.Deadline(options.deadline.Add(asynqDeadlineGrace)))
 	}
-	asynqOpts := make([]asynq.Option, 0)
-	if options.delayDuration > 0 {
-		asynqOpts = append(asynqOpts, asynq.ProcessAt(time.Now().Add(options.delayDuration)))
+	if options.timeout > 0 {
+		asynqOpts = append(asynqOpts, asynq.Timeout(options.timeout+asynqDeadlineGrace))
 	}
 	return asynqOpts
 }
 
-func (r *RedisQueue) parseMessageToBytes(message any) ([]byte, error) {
-	switch result := message.(type) {
-	case []byte:
-		return result, nil
-	case encoding.BinaryMarshaler:
-		return result.MarshalBinary()
-	default:
-		return nil, fmt.Errorf("unsupported message type: %T, only support []byte|encoding.BinaryMarshaler", message)
+// retryDelay 根据订阅者注册时的 RetryBackoff 计算重试间隔. 消息匹配到多个订阅者时使用默认的重试间隔
+func (r *RedisQueue) retryDelay(retried int, err error, task *asynq.Task) time.Duration {
+	if r.requeue(err) {
+		return 0
+	}
+	if errors.Is(err, errTopicPaused) {
+		return pausedRetryDelay
+	}
+	if errors.Is(err, errOrderingWait) {
+		return orderingRetryDelay
+	}
+	if errors.Is(err, errConcurrencyLimited) {
+		return concurrencyRetryDelay
 	}
+	envelope := decodeEnvelope(task.Payload())
+	if subscriptions := r.router.route(task.Type(), envelope.Metadata.Get(MetadataSubscription)); len(subscriptions) == 1 {
+		return subscriptions[0].options.retryBackoff(retried, err)
+	}
+	return DefaultRetryBackoff(retried, err)
+}
 
+// requeue 返回消息是否因为停止订阅超时被取消. 被取消的消息立即重新入队并且不计入重试次数
+func (r *RedisQueue) requeue(err error) bool {
+	return r.aborted.Load() && errors.Is(err, context.Canceled)
 }
 
-func NewRedisQueue(logger logger.Logger, address string, db int, password string) (Queue, error) {
-	redisClientOpt := asynq.RedisClientOpt{
-		Addr:     address,
+// isFailure 返回错误是否计入重试次数. 被取消, 主题暂停, 等待顺序键以及达到并发限制的消息延迟后再次投递
+func (r *RedisQueue) isFailure(err error) bool {
+	return !r.requeue(err) && !errors.Is(err, errTopicPaused) && !errors.Is(err, errOrderingWait) &&
+		!errors.Is(err, errConcurrencyLimited)
+}
+
+// NewRedisQueue 创建基于 redis(asynq) 的队列. opts 会作为该队列所有发布以及订阅的默认选项
+func NewRedisQueue(logger logger.Logger, address string, db int, password string, opts ...Option) (Queue, error) {
+	return NewRedisQueueWithConfig(logger, RedisQueueConfig{
+		Address:  address,
 		DB:       db,
 		Password: password,
+	}, opts...)
+}
+
+// NewRedisQueueWithConfig 通过配置创建基于 redis(asynq) 的队列. opts 会作为该队列所有发布以及订阅的默认选项
+func NewRedisQueueWithConfig(logger logger.Logger, config RedisQueueConfig, opts ...Option) (Queue, error) {
+	redisClientOpt := config.redisClientOpt()
+	queue := &RedisQueue{
+		logger:          logger,
+		asynqClient:     asynq.NewClient(redisClientOpt),
+		router:          newRouter(),
+		options:         opts,
+		shutdownTimeout: config.getShutdownTimeout(),
+		redisClient:     redisClientOpt.MakeRedisClient().(redis.UniversalClient),
+	}
+	queue.pausedTopics = &pausedTopics{client: queue.redisClient}
+	queue.unique = NewRedisDedupStore(queue.redisClient, uniqueKeyPrefix)
+	queue.ordering = &redisOrdering{client: queue.redisClient}
+	queue.replies = &redisReplies{client: queue.redisClient, logger: logger}
+	queue.baseCtx, queue.cancelBase = context.WithCancel(context.Background())
+	queue.asynqServer = asynq.NewServer(redisClientOpt, queue.asynqConfig(config))
+	return queue, nil
+}
+
+// asynqConfig 将队列的配置转换为 asynq 服务端的配置
+func (r *RedisQueue) asynqConfig(config RedisQueueConfig) asynq.Config {
+	return asynq.Config{
+		Concurrency:     config.Concurrency,
+		Queues:          config.getQueues(),
+		StrictPriority:  config.StrictPriority,
+		ShutdownTimeout: r.shutdownTimeout,
+		RetryDelayFunc:  r.retryDelay,
+		// 等待中的顺序消息以及暂停的主题中的消息通过重试再次投递, 检查间隔决定了其延迟
+		DelayedTaskCheckInterval: config.DelayedTaskCheckInterval,
+		BaseContext: func() context.Context {
+			return r.baseCtx
+		},
+		IsFailure: r.isFailure,
 	}
-	server := asynq.NewServer(redisClientOpt, asynq.Config{})
-	client := asynq.NewClient(redisClientOpt)
-	return &RedisQueue{
-		logger:        logger,
-		asynqServer:   server,
-		asynqClient:   client,
-		asynqServeMux: asynq.NewServeMux(),
-	}, nil
 }
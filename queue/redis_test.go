@@ -0,0 +1,67 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+	"github.com/hibiken/asynq"
+)
+
+// asynqOptionValue 返回 asynq 选项中指定类型的值
+func asynqOptionValue(opts []asynq.Option, typ asynq.OptionType) any {
+	for _, opt := range opts {
+		if opt.Type() == typ {
+			return opt.Value()
+		}
+	}
+	return nil
+}
+
+func TestRedisQueue_MappingAsynqOptionsDeadline(t *testing.T) {
+	queue := &RedisQueue{}
+	deadline := time.Now().Add(time.Minute)
+	opts := queue.mappingAsynqOptions(newOptions([]Option{WithDeadline(deadline), WithTimeout(time.Second)}))
+	// asynq 在消息的截止时间之后额外等待, 超时的消息由订阅方重试以及投递到死信主题
+	assert.Equal(t, deadline.Add(asynqDeadlineGrace), asynqOptionValue(opts, asynq.DeadlineOpt))
+	assert.Equal(t, time.Second+asynqDeadlineGrace, asynqOptionValue(opts, asynq.TimeoutOpt))
+
+	opts = queue.mappingAsynqOptions(newOptions(nil))
+	assert.Equal(t, nil, asynqOptionValue(opts, asynq.DeadlineOpt))
+	assert.Equal(t, nil, asynqOptionValue(opts, asynq.TimeoutOpt))
+}
+
+// contextQueue 记录发布消息时 ctx 的错误, 发布返回 publishErr
+type contextQueue struct {
+	Queue
+	err        error
+	publishErr error
+}
+
+func (q *contextQueue) Publish(ctx context.Context, topic string, message any, opts ...Option) error {
+	q.err = ctx.Err()
+	return q.publishErr
+}
+
+func TestPublishDeadLetter_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	queue := &contextQueue{}
+	err := publishDeadLetter(ctx, queue, "order.dlq", "order", &envelope{}, 1, context.DeadlineExceeded)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, queue.err)
+}
+
+func TestRedisQueue_MappingAsynqOptionsQueue(t *testing.T) {
+	queue := &RedisQueue{}
+	opts := queue.mappingAsynqOptions(newOptions([]Option{WithMaxRetry(3)}))
+	assert.Equal(t, 3, asynqOptionValue(opts, asynq.MaxRetryOpt))
+	// 未指定优先级以及队列名称的消息由 asynq 投递到默认队列
+	assert.Equal(t, nil, asynqOptionValue(opts, asynq.QueueOpt))
+
+	opts = queue.mappingAsynqOptions(newOptions([]Option{WithPriority(PriorityCritical)}))
+	assert.Equal(t, string(PriorityCritical), asynqOptionValue(opts, asynq.QueueOpt))
+	opts = queue.mappingAsynqOptions(newOptions([]Option{WithPriority(PriorityLow), WithQueueName("reports")}))
+	assert.Equal(t, "reports", asynqOptionValue(opts, asynq.QueueOpt))
+}
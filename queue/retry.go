@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	// DefaultMaxRetry 默认的最大重试次数. 与 asynq 保持一致
+	DefaultMaxRetry = 25
+
+	deadLetterTopicSuffix = ".dlq"
+)
+
+type (
+	// RetryBackoff 计算消息处理失败后到下一次重试的间隔
+	// retried 为该消息已经重试的次数, err 为本次处理返回的错误
+	RetryBackoff func(retried int, err error) time.Duration
+
+	// DeadLetter 重试耗尽后投递到死信主题的消息.
+	// 订阅死信主题时可以使用 CreateSubscriber[*DeadLetter] 解析, 将 Payload 重新发布到 Topic 即可重放
+	DeadLetter struct {
+		Topic    string    `json:"topic"`
+		Payload  []byte    `json:"payload"`
+		Metadata Metadata  `json:"metadata,omitempty"`
+		Error    string    `json:"error"`
+		Retried  int       `json:"retried"`
+		FailedAt time.Time `json:"failed_at"`
+	}
+)
+
+func (d *DeadLetter) MarshalBinary() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+func (d *DeadLetter) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, d)
+}
+
+// DefaultRetryBackoff 默认的重试间隔. 与 asynq 的默认策略一致, 随重试次数多项式增长并带有随机抖动
+func DefaultRetryBackoff(retried int, err error) time.Duration {
+	seconds := int(math.Pow(float64(retried), 4)) + 15 + rand.Intn(30)*(retried+1)
+	return time.Duration(seconds) * time.Second
+}
+
+// ConstantBackoff 固定间隔的重试策略
+func ConstantBackoff(interval time.Duration) RetryBackoff {
+	return func(retried int, err error) time.Duration {
+		return interval
+	}
+}
+
+// ExponentialBackoff 指数增长的重试策略. 第 n 次重试的间隔为 base*2^n, 最大不超过 max
+func ExponentialBackoff(base, max time.Duration) RetryBackoff {
+	return func(retried int, err error) time.Duration {
+		backoff := base * time.Duration(math.Pow(2, float64(retried)))
+		if backoff <= 0 || backoff > max {
+			return max
+		}
+		return backoff
+	}
+}
+
+// DeadLetterTopic 返回 topic 默认的死信主题: <topic>.dlq
+func DeadLetterTopic(topic string) string {
+	return topic + deadLetterTopicSuffix
+}
+
+// publishDeadLetter 将重试耗尽的消息以及最后一次的错误投递到死信主题.
+// 超时的消息 ctx 已经被取消, 投递死信时不使用 ctx 的取消
+func publishDeadLetter(ctx context.Context, queue Queue, deadLetterTopic, topic string, envelope *envelope, retried int, err error) error {
+	return queue.Publish(context.WithoutCancel(ctx), deadLetterTopic, newDeadLetter(topic, envelope, retried, err), WithCodec(BinaryCodec))
+}
+
+func newDeadLetter(topic string, envelope *envelope, retried int, err error) *DeadLetter {
+	return &DeadLetter{
+		Topic:    topic,
+		Payload:  envelope.Payload,
+		Metadata: envelope.Metadata,
+		Error:    err.Error(),
+		Retried:  retried,
+		FailedAt: time.Now(),
+	}
+}
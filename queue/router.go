@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// MetadataSubscription 消息的元数据. 一条消息被多个订阅者订阅时, 每个订阅者收到的副本中记录了该订阅者的标识
+	MetadataSubscription = "subscription"
+
+	// topicSeparator 主题的层级分隔符
+	topicSeparator = "."
+	// wildcardSingle 匹配主题中的一个层级
+	wildcardSingle = "*"
+	// wildcardMulti 出现在模式的最后, 匹配主题中剩余的一个或者多个层级
+	wildcardMulti = ">"
+)
+
+// ErrNoSubscriber 消息没有匹配的订阅者
+var ErrNoSubscriber = errors.New("no subscriber")
+
+// router 按照主题以及订阅者标识查找订阅
+type router struct {
+	mu            sync.RWMutex
+	subscriptions map[string]*subscription
+}
+
+// subscriptionKey 返回订阅者的标识. 同一个主题模式下不同消费组的订阅者相互独立
+func subscriptionKey(pattern, group string) string {
+	if group == "" {
+		return pattern
+	}
+	return pattern + "@" + group
+}
+
+// add 注册订阅. 相同主题模式以及消费组的订阅会被替换
+func (r *router) add(pattern string, subscription *subscription) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	subscription.pattern = pattern
+	subscription.key = subscriptionKey(pattern, subscription.options.group)
+	r.subscriptions[subscription.key] = subscription
+}
+
+// route 返回消息需要投递的订阅. key 不为空时消息为某个订阅者的副本, 只投递给该订阅者
+func (r *router) route(topic, key string) []*subscription {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if key != "" {
+		if target, ok := r.subscriptions[key]; ok {
+			return []*subscription{target}
+		}
+		return nil
+	}
+	matched := make([]*subscription, 0, 1)
+	for _, target := range r.subscriptions {
+		if matchTopic(target.pattern, topic) {
+			matched = append(matched, target)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].key < matched[j].key
+	})
+	return matched
+}
+
+// topics 返回所有不包含通配符的订阅主题
+func (r *router) topics() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	topics := make([]string, 0, len(r.subscriptions))
+	for _, target := range r.subscriptions {
+		if !strings.Contains(target.pattern, wildcardSingle) && !strings.Contains(target.pattern, wildcardMulti) {
+			topics = append(topics, target.pattern)
+		}
+	}
+	return topics
+}
+
+// matchTopic 返回主题是否匹配模式. 模式按照 . 分隔层级, * 匹配一个层级, 位于最后的 > 匹配剩余的一个或者多个层级.
+// 通配符不匹配默认的死信主题, 否则开启死信的订阅者会收到自己投递的死信并不断生成新的死信主题.
+// 订阅死信主题时需要以 .dlq 结尾, 例如 order.*.dlq
+func matchTopic(pattern, topic string) bool {
+	if pattern == topic {
+		return true
+	}
+	if strings.HasSuffix(topic, deadLetterTopicSuffix) && !strings.HasSuffix(pattern, deadLetterTopicSuffix) {
+		return false
+	}
+	patterns := strings.Split(pattern, topicSeparator)
+	topics := strings.Split(topic, topicSeparator)
+	for i, segment := range patterns {
+		if segment == wildcardMulti && i == len(patterns)-1 {
+			return len(topics) > i
+		}
+		if i >= len(topics) || (segment != wildcardSingle && segment != topics[i]) {
+			return false
+		}
+	}
+	return len(topics) == len(patterns)
+}
+
+func newRouter() *router {
+	return &router{subscriptions: make(map[string]*subscription)}
+}
@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+func TestMatchTopic(t *testing.T) {
+	testcases := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{pattern: "order.created", topic: "order.created", want: true},
+		{pattern: "order.created", topic: "order.paid", want: false},
+		{pattern: "order.*", topic: "order.created", want: true},
+		{pattern: "order.*", topic: "order", want: false},
+		{pattern: "order.*", topic: "order.created.dlq", want: false},
+		{pattern: "*.created", topic: "order.created", want: true},
+		{pattern: "order.>", topic: "order.created.dlq", want: false},
+		{pattern: "order.*.dlq", topic: "order.created.dlq", want: true},
+		{pattern: "order.created.dlq", topic: "order.created.dlq", want: true},
+		{pattern: "order.>", topic: "order.created.retry", want: true},
+		{pattern: "order.>", topic: "order", want: false},
+	}
+	for _, testcase := range testcases {
+		assert.Equal(t, testcase.want, matchTopic(testcase.pattern, testcase.topic), testcase.pattern, testcase.topic)
+	}
+}
+
+func TestMemoryQueue_FanOut(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 2)
+	received := make(chan string, 3)
+	subscriber := func(name string) Subscriber {
+		return SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+			received <- name + ":" + topic + ":" + MessageIDFromContext(ctx)
+			return nil
+		})
+	}
+	queue.SubscribeTo("order.created", subscriber("billing"), WithGroup("billing"))
+	queue.SubscribeTo("order.created", subscriber("shipping"), WithGroup("shipping"))
+	queue.SubscribeTo("order.*", subscriber("audit"))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	assert.Equal(t, nil, queue.Publish(context.Background(), "order.created", []byte("foo"), WithMessageID("order-1")))
+	messages := []string{waitMessage(t, received), waitMessage(t, received), waitMessage(t, received)}
+	sort.Strings(messages)
+	assert.Equal(t, []string{
+		"audit:order.created:order-1",
+		"billing:order.created:order-1",
+		"shipping:order.created:order-1",
+	}, messages)
+}
+
+func TestMemoryQueue_WildcardDeadLetter(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 2, WithRetryBackoff(ConstantBackoff(time.Millisecond)))
+	received := make(chan string, 4)
+	queue.SubscribeTo("order.>", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		received <- topic
+		return errors.New("boom")
+	}), WithMaxRetry(0), WithDeadLetter())
+	queue.SubscribeTo("order.*.dlq", CreateSubscriber(func(ctx context.Context, topic string, message *DeadLetter) error {
+		received <- topic
+		return nil
+	}))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	assert.Equal(t, nil, queue.Publish(context.Background(), "order.created", []byte("foo")))
+	assert.Equal(t, "order.created", waitMessage(t, received))
+	assert.Equal(t, "order.created.dlq", waitMessage(t, received))
+	select {
+	case topic := <-received:
+		t.Fatalf("unexpected message on %s", topic)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,185 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+const (
+	// MetadataReplyTo 请求消息的元数据, 响应需要发布到的主题
+	MetadataReplyTo = "reply-to"
+	// MetadataCorrelationID 请求以及响应消息的元数据, 用于关联请求与响应
+	MetadataCorrelationID = "correlation-id"
+	// ReplyTopicPrefix Requester 接收响应的主题的前缀
+	ReplyTopicPrefix = "queue.reply."
+)
+
+var ErrRequestTimeout = errors.New("request timeout")
+
+type (
+	// Reply 响应消息. 订阅者处理失败时 Error 为错误信息
+	Reply struct {
+		Payload []byte `json:"payload,omitempty"`
+		Error   string `json:"error,omitempty"`
+	}
+
+	// ReplyError 订阅者处理请求失败时 Request 返回的错误
+	ReplyError struct {
+		Message string
+	}
+
+	// replyTransport 将响应直接投递到发起请求的进程的队列. 多个进程共享同一个队列时,
+	// 响应主题只有发起请求的进程订阅, 通过队列投递的响应会被其他进程拉取
+	replyTransport interface {
+		subscribeReply(topic string, subscriber Subscriber, opts ...Option)
+	}
+
+	// Requester 通过队列发送请求并等待响应. 每个 Requester 订阅一个独立的响应主题
+	Requester struct {
+		queue      Queue
+		logger     logger.Logger
+		options    []Option
+		replyTopic string
+
+		mu    sync.Mutex
+		calls map[string]chan *Reply
+	}
+)
+
+func (r *Reply) MarshalBinary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *Reply) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+func (e *ReplyError) Error() string {
+	return e.Message
+}
+
+// ReplyTopic 返回接收响应的主题
+func (r *Requester) ReplyTopic() string {
+	return r.replyTopic
+}
+
+// Request 发布请求消息并等待响应, 返回编码后的响应. 超过 timeout 未收到响应时返回 ErrRequestTimeout,
+// 订阅者处理失败时返回 *ReplyError
+func (r *Requester) Request(ctx context.Context, topic string, message any, timeout time.Duration, opts ...Option) ([]byte, error) {
+	correlationID := uuid.NewString()
+	call := make(chan *Reply, 1)
+	r.mu.Lock()
+	r.calls[correlationID] = call
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.calls, correlationID)
+		r.mu.Unlock()
+	}()
+
+	requestCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	opts = mergeOptions(r.options, opts)
+	opts = append(opts[:len(opts):len(opts)],
+		WithMetadata(MetadataReplyTo, r.replyTopic), WithMetadata(MetadataCorrelationID, correlationID))
+	if err := r.queue.Publish(requestCtx, topic, message, opts...); err != nil {
+		return nil, err
+	}
+	select {
+	case reply := <-call:
+		if reply.Error != "" {
+			return nil, &ReplyError{Message: reply.Error}
+		}
+		return reply.Payload, nil
+	case <-requestCtx.Done():
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, ErrRequestTimeout
+	}
+}
+
+func (r *Requester) receive(ctx context.Context, topic string, reply *Reply) error {
+	correlationID := MetadataFromContext(ctx).Get(MetadataCorrelationID)
+	r.mu.Lock()
+	call, ok := r.calls[correlationID]
+	r.mu.Unlock()
+	if !ok {
+		r.logger.Warn(ctx, "no pending request for reply, reply dropped",
+			zap.String("topic", topic), zap.String("correlation_id", correlationID))
+		return nil
+	}
+	select {
+	case call <- reply:
+	default:
+		// 重复投递的响应, Request 只读取一次
+		r.logger.Warn(ctx, "duplicated reply, reply dropped",
+			zap.String("topic", topic), zap.String("correlation_id", correlationID))
+	}
+	return nil
+}
+
+// Request 发布请求消息并等待响应, 响应使用创建 Requester 以及本次调用指定的 Codec 解码
+func Request[T any](ctx context.Context, requester *Requester, topic string, message any, timeout time.Duration, opts ...Option) (T, error) {
+	payload, err := requester.Request(ctx, topic, message, timeout, opts...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return decodeMessage[T](newOptions(mergeOptions(requester.options, opts)).codec, payload)
+}
+
+// ReplyingSubscriber 返回处理请求的订阅者. 处理函数的返回值使用注册订阅者时指定的 Codec 编码后发布到请求的响应主题.
+// 处理函数返回的错误会作为响应返回给请求方, 消息不会重试. 消息不是通过 Requester 发送时不发布响应.
+// 响应发布失败时只记录日志, 请求方会在超时后返回 ErrRequestTimeout
+func ReplyingSubscriber[Req, Resp any](queue Queue, logger logger.Logger, handleFunc func(ctx context.Context, topic string, message Req) (Resp, error)) Subscriber {
+	return CreateSubscriber(func(ctx context.Context, topic string, message Req) error {
+		response, err := handleFunc(ctx, topic, message)
+		metadata := MetadataFromContext(ctx)
+		replyTo := metadata.Get(MetadataReplyTo)
+		if replyTo == "" {
+			return err
+		}
+		reply := &Reply{}
+		if err == nil {
+			reply.Payload, err = CodecFromContext(ctx).Marshal(response)
+		}
+		if err != nil {
+			reply.Error = err.Error()
+		}
+		// 处理超时时 ctx 已经被取消, 仍然发布响应. 请求已经处理完成, 重试会重复处理请求
+		if err := queue.Publish(context.WithoutCancel(ctx), replyTo, reply, WithCodec(BinaryCodec),
+			WithMetadata(MetadataCorrelationID, metadata.Get(MetadataCorrelationID))); err != nil {
+			logger.Error(ctx, "failed to publish reply", err, zap.String("topic", topic), zap.String("reply_to", replyTo))
+		}
+		return nil
+	})
+}
+
+// NewRequester 创建 Requester 并在 queue 中订阅响应主题.
+// RedisQueue 通过 redis 的发布订阅将响应直接投递到当前进程, 不需要启动订阅, 支持多副本部署.
+// 其他队列通过订阅者接收响应, 需要启动订阅, 并且 SQLQueue 只适用于单副本部署, 否则响应可能被其他副本取出后重试直到请求超时.
+// opts 会作为所有请求的默认选项, 其中的 Codec 同时用于 Request 解码响应
+func NewRequester(queue Queue, logger logger.Logger, opts ...Option) *Requester {
+	requester := &Requester{
+		queue:      queue,
+		logger:     logger,
+		options:    opts,
+		replyTopic: ReplyTopicPrefix + uuid.NewString(),
+		calls:      make(map[string]chan *Reply),
+	}
+	if transport, ok := queue.(replyTransport); ok {
+		transport.subscribeReply(requester.replyTopic, CreateSubscriber(requester.receive), WithMaxRetry(0))
+		return requester
+	}
+	queue.SubscribeTo(requester.replyTopic, CreateSubscriber(requester.receive), WithMaxRetry(0))
+	return requester
+}
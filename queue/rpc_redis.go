@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+// replyChannelPrefix 响应主题在 redis 中的发布订阅频道的前缀
+const replyChannelPrefix = "queue:reply:"
+
+// redisReplies 通过 redis 的发布订阅投递响应. 响应主题只有发起请求的进程订阅,
+// 通过 asynq 投递时响应可能被其他进程拉取, 没有该订阅者的进程会重试导致请求超时
+type redisReplies struct {
+	client redis.UniversalClient
+	logger logger.Logger
+
+	mu      sync.Mutex
+	pubsubs []*redis.PubSub
+}
+
+func (r *redisReplies) publish(ctx context.Context, topic string, data []byte) error {
+	return r.client.Publish(ctx, replyChannelPrefix+topic, data).Err()
+}
+
+// subscribe 订阅响应主题. 订阅成功后返回, 避免请求发出后才开始订阅导致响应丢失
+func (r *redisReplies) subscribe(topic string, subscription *subscription) {
+	ctx := context.Background()
+	pubsub := r.client.Subscribe(ctx, replyChannelPrefix+topic)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		// 连接恢复后会自动重新订阅
+		r.logger.Error(ctx, "failed to subscribe reply topic", err, zap.String("topic", topic))
+	}
+	r.mu.Lock()
+	r.pubsubs = append(r.pubsubs, pubsub)
+	r.mu.Unlock()
+	go func() {
+		for message := range pubsub.Channel() {
+			if _, _, err := subscription.consume(ctx, topic, []byte(message.Payload)); err != nil {
+				r.logger.Error(ctx, "failed to handle reply", err, zap.String("topic", topic))
+			}
+		}
+	}()
+}
+
+func (r *redisReplies) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	errs := make([]error, 0, len(r.pubsubs))
+	for _, pubsub := range r.pubsubs {
+		errs = append(errs, pubsub.Close())
+	}
+	r.pubsubs = nil
+	return errors.Join(errs...)
+}
+
+// subscribeReply 在当前进程中订阅响应主题, 不需要启动订阅
+func (r *RedisQueue) subscribeReply(topic string, subscriber Subscriber, opts ...Option) {
+	subscription := newSubscription(subscriber, newOptions(mergeOptions(r.options, opts)))
+	subscription.pattern, subscription.key = topic, topic
+	r.replies.subscribe(topic, subscription)
+}
+
+// publishReply 将响应发布到发起请求的进程
+func (r *RedisQueue) publishReply(ctx context.Context, topic string, envelope *envelope) (string, error) {
+	data, err := encodeEnvelope(envelope)
+	if err == nil {
+		err = r.replies.publish(ctx, topic, data)
+	}
+	recordPublish(ctx, topic, err)
+	if err != nil {
+		return "", err
+	}
+	return envelope.ID, nil
+}
@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bmizerany/assert"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+func TestRequester_Request(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 2)
+	queue.SubscribeTo("order.create", ReplyingSubscriber(queue, logger.NewNoopLogger(), func(ctx context.Context, topic string, message *orderCreated) (*orderCreated, error) {
+		if message.ID == 0 {
+			return nil, errors.New("invalid order")
+		}
+		return &orderCreated{ID: message.ID, Status: "created"}, nil
+	}), WithCodec(JSONCodec))
+	queue.SubscribeTo("order.ignore", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		return nil
+	}))
+	requester := NewRequester(queue, logger.NewNoopLogger(), WithCodec(JSONCodec))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	ctx := context.Background()
+	response, err := Request[*orderCreated](ctx, requester, "order.create", &orderCreated{ID: 1}, time.Second)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &orderCreated{ID: 1, Status: "created"}, response)
+
+	_, err = Request[*orderCreated](ctx, requester, "order.create", &orderCreated{}, time.Second)
+	var replyErr *ReplyError
+	assert.T(t, errors.As(err, &replyErr))
+	assert.Equal(t, "invalid order", replyErr.Message)
+
+	_, err = requester.Request(ctx, "order.ignore", &orderCreated{ID: 1}, 50*time.Millisecond)
+	assert.Equal(t, ErrRequestTimeout, err)
+}
+
+func TestRequester_DuplicatedReply(t *testing.T) {
+	requester := NewRequester(NewMemoryQueue(logger.NewNoopLogger(), 1), logger.NewNoopLogger())
+	call := make(chan *Reply, 1)
+	requester.calls["request-1"] = call
+	ctx := contextWithMetadata(context.Background(), Metadata{MetadataCorrelationID: "request-1"})
+
+	done := make(chan struct{})
+	go func() {
+		assert.Equal(t, nil, requester.receive(ctx, requester.ReplyTopic(), &Reply{Payload: []byte("foo")}))
+		assert.Equal(t, nil, requester.receive(ctx, requester.ReplyTopic(), &Reply{Payload: []byte("bar")}))
+		close(done)
+	}()
+	waitMessage(t, done)
+	assert.Equal(t, "foo", string((<-call).Payload))
+}
+
+// replyTransportQueue 将响应直接投递给 Requester, 模拟 RedisQueue 的发布订阅
+type replyTransportQueue struct {
+	Queue
+	reply Subscriber
+}
+
+func (q *replyTransportQueue) subscribeReply(topic string, subscriber Subscriber, opts ...Option) {
+	q.reply = newSubscription(subscriber, newOptions(opts)).subscriber
+}
+
+func (q *replyTransportQueue) Publish(ctx context.Context, topic string, message any, opts ...Option) error {
+	if strings.HasPrefix(topic, ReplyTopicPrefix) {
+		options := newOptions(opts)
+		data, err := options.codec.Marshal(message)
+		if err != nil {
+			return err
+		}
+		return q.reply.Subscribe(contextWithMetadata(ctx, options.metadata), topic, data)
+	}
+	return q.Queue.Publish(ctx, topic, message, opts...)
+}
+
+func TestRequester_ReplyTransport(t *testing.T) {
+	queue := &replyTransportQueue{Queue: NewMemoryQueue(logger.NewNoopLogger(), 1)}
+	queue.SubscribeTo("order.create", ReplyingSubscriber(queue, logger.NewNoopLogger(), func(ctx context.Context, topic string, message []byte) ([]byte, error) {
+		return append([]byte("created:"), message...), nil
+	}))
+	requester := NewRequester(queue, logger.NewNoopLogger())
+	assert.NotEqual(t, nil, queue.reply)
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	response, err := requester.Request(context.Background(), "order.create", []byte("1"), time.Second)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "created:1", string(response))
+	_, subscribed := queue.Queue.(*MemoryQueue).router.subscriptions[requester.ReplyTopic()]
+	assert.Equal(t, false, subscribed)
+}
+
+func TestReplyingSubscriber_Cancelled(t *testing.T) {
+	queue := &contextQueue{publishErr: errors.New("boom")}
+	subscriber := ReplyingSubscriber(queue, logger.NewNoopLogger(), func(ctx context.Context, topic string, message []byte) ([]byte, error) {
+		return message, nil
+	})
+	ctx, cancel := context.WithCancel(contextWithMetadata(context.Background(), Metadata{MetadataReplyTo: ReplyTopicPrefix + "1"}))
+	cancel()
+	// 处理超时仍然发布响应, 发布失败时不重试请求
+	assert.Equal(t, nil, subscriber.Subscribe(ctx, "order.create", []byte("1")))
+	assert.Equal(t, nil, queue.err)
+}
+
+func TestRedisQueue_Reply(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	queue := &RedisQueue{replies: &redisReplies{client: client, logger: logger.NewNoopLogger()}}
+	replies := make(chan *Reply, 1)
+	topic := ReplyTopicPrefix + "1"
+	queue.subscribeReply(topic, CreateSubscriber(func(ctx context.Context, topic string, reply *Reply) error {
+		assert.Equal(t, "correlation-1", MetadataFromContext(ctx).Get(MetadataCorrelationID))
+		replies <- reply
+		return nil
+	}), WithMaxRetry(0))
+
+	ctx := context.Background()
+	envelope, err := newEnvelope(ctx, newOptions([]Option{WithCodec(BinaryCodec), WithMetadata(MetadataCorrelationID, "correlation-1")}),
+		&Reply{Payload: []byte("created")})
+	assert.Equal(t, nil, err)
+	_, err = queue.publishReply(ctx, topic, envelope)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Reply{Payload: []byte("created")}, waitMessage(t, replies))
+	assert.Equal(t, nil, queue.replies.close())
+}
@@ -0,0 +1,159 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+const (
+	scheduleLockPrefix = "schedule:"
+	minScheduleLockTTL = time.Second
+)
+
+var (
+	ErrScheduleExists   = errors.New("schedule already exists")
+	ErrScheduleNotFound = errors.New("schedule not found")
+)
+
+type (
+	// Schedule 定时发布的计划
+	Schedule struct {
+		ID    string
+		Spec  string
+		Topic string
+		// Next 下一次发布的时间
+		Next time.Time
+	}
+
+	// Scheduler 定时发布消息. 按照 cron 表达式周期性地将消息发布到主题中.
+	// 多个副本注册相同ID的计划时, 通过 Locker 保证每个周期只有一个副本发布消息
+	Scheduler struct {
+		queue  Queue
+		logger logger.Logger
+		locker Locker
+		cron   *cron.Cron
+
+		mu      sync.RWMutex
+		entries map[string]*scheduleEntry
+	}
+
+	scheduleEntry struct {
+		id       string
+		spec     string
+		topic    string
+		message  any
+		opts     []Option
+		schedule cron.Schedule
+		entryID  cron.EntryID
+	}
+)
+
+// Register 注册定时发布的计划. spec 为标准的 cron 表达式, 同时支持 @every 1m 等描述符.
+// id 在所有副本之间需要保持一致, 用于副本之间的互斥
+func (s *Scheduler) Register(id, spec, topic string, message any, opts ...Option) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[id]; ok {
+		return ErrScheduleExists
+	}
+	entry := &scheduleEntry{
+		id:       id,
+		spec:     spec,
+		topic:    topic,
+		message:  message,
+		opts:     opts,
+		schedule: schedule,
+	}
+	entry.entryID = s.cron.Schedule(schedule, cron.FuncJob(func() {
+		s.fire(entry)
+	}))
+	s.entries[id] = entry
+	return nil
+}
+
+// Remove 移除定时发布的计划
+func (s *Scheduler) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return ErrScheduleNotFound
+	}
+	s.cron.Remove(entry.entryID)
+	delete(s.entries, id)
+	return nil
+}
+
+// Schedules 返回所有已注册的计划. 按照ID排序
+func (s *Scheduler) Schedules() []Schedule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schedules := make([]Schedule, 0, len(s.entries))
+	for _, entry := range s.entries {
+		schedules = append(schedules, Schedule{
+			ID:    entry.id,
+			Spec:  entry.spec,
+			Topic: entry.topic,
+			Next:  s.cron.Entry(entry.entryID).Next,
+		})
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].ID < schedules[j].ID
+	})
+	return schedules
+}
+
+// Start 异步启动定时发布
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop 停止定时发布. 等待正在发布的消息完成
+func (s *Scheduler) Stop() {
+	<-s.cron.Stop().Done()
+}
+
+func (s *Scheduler) fire(entry *scheduleEntry) {
+	ctx := context.Background()
+	now := time.Now()
+	// 锁的有效期为半个周期. 副本之间的时钟误差小于半个周期时, 每个周期只有一个副本能获取到锁
+	lockTTL := entry.schedule.Next(now).Sub(now) / 2
+	if lockTTL < minScheduleLockTTL {
+		lockTTL = minScheduleLockTTL
+	}
+	locked, err := s.locker.TryLock(ctx, scheduleLockPrefix+entry.id, lockTTL)
+	if err != nil {
+		s.logger.Error(ctx, "failed to acquire schedule lock", err, zap.String("schedule", entry.id))
+		return
+	}
+	if !locked {
+		return
+	}
+	if err := s.queue.Publish(ctx, entry.topic, entry.message, entry.opts...); err != nil {
+		s.logger.Error(ctx, "failed to publish scheduled message", err,
+			zap.String("schedule", entry.id), zap.String("topic", entry.topic))
+	}
+}
+
+// NewScheduler 创建定时发布器. locker 用于副本之间的互斥, 单副本部署时可以使用 NewMemoryLocker
+func NewScheduler(queue Queue, logger logger.Logger, locker Locker) *Scheduler {
+	return &Scheduler{
+		queue:   queue,
+		logger:  logger,
+		locker:  locker,
+		cron:    cron.New(),
+		entries: make(map[string]*scheduleEntry),
+	}
+}
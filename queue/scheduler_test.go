@@ -0,0 +1,66 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+func TestScheduler(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1)
+	received := make(chan string, 4)
+	queue.SubscribeTo("report", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		received <- string(message)
+		return nil
+	}))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	locker := NewMemoryLocker()
+	replicas := []*Scheduler{
+		NewScheduler(queue, logger.NewNoopLogger(), locker),
+		NewScheduler(queue, logger.NewNoopLogger(), locker),
+	}
+	for _, scheduler := range replicas {
+		assert.Equal(t, nil, scheduler.Register("daily-report", "@every 1s", "report", []byte("generate")))
+		assert.Equal(t, ErrScheduleExists, scheduler.Register("daily-report", "@every 1s", "report", []byte("generate")))
+		scheduler.Start()
+		defer scheduler.Stop()
+	}
+	assert.NotEqual(t, nil, replicas[0].Register("invalid", "invalid spec", "report", []byte("generate")))
+
+	schedules := replicas[0].Schedules()
+	assert.Equal(t, 1, len(schedules))
+	assert.Equal(t, "daily-report", schedules[0].ID)
+	assert.Equal(t, "report", schedules[0].Topic)
+
+	assert.Equal(t, "generate", waitMessage(t, received))
+	select {
+	case <-received:
+		t.Fatal("scheduled message published by more than one replica")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	assert.Equal(t, nil, replicas[0].Remove("daily-report"))
+	assert.Equal(t, ErrScheduleNotFound, replicas[0].Remove("daily-report"))
+	assert.Equal(t, 0, len(replicas[0].Schedules()))
+}
+
+func TestMemoryQueue_WithProcessAt(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1)
+	received := make(chan time.Time, 1)
+	queue.SubscribeTo("foo", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		received <- time.Now()
+		return nil
+	}))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	processAt := time.Now().Add(100 * time.Millisecond)
+	assert.Equal(t, nil, queue.Publish(context.Background(), "foo", []byte("bar"), WithProcessAt(processAt)))
+	assert.T(t, !waitMessage(t, received).Before(processAt))
+}
@@ -0,0 +1,452 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/chaihaobo/gocommon/logger"
+	"github.com/chaihaobo/gocommon/mysql"
+)
+
+const (
+	DefaultSQLQueueTable             = "queue_messages"
+	DefaultSQLQueuePollInterval      = time.Second
+	DefaultSQLQueueVisibilityTimeout = 30 * time.Second
+)
+
+var _ Queue = (*SQLQueue)(nil)
+
+// errAttemptsExhausted 最后一次处理没有返回结果, 例如进程在处理消息时退出. 消息不再重试
+var errAttemptsExhausted = errors.New("message attempts exhausted without result")
+
+type (
+	// SQLQueueConfig 基于 mysql 的队列的配置
+	SQLQueueConfig struct {
+		// Table 保存消息的表名. 不设置时为 DefaultSQLQueueTable
+		Table string
+		// Concurrency 同时处理消息的 goroutine 数量. 不设置时使用 CPU 核数
+		Concurrency int
+		// PollInterval 没有可处理的消息时轮询的间隔. 不设置时为 DefaultSQLQueuePollInterval
+		PollInterval time.Duration
+		// VisibilityTimeout 消息被取出后对其他消费者不可见的时间, 同时也是订阅者处理消息的超时时间.
+		// 进程在处理消息时退出, 消息会在超时后被重新投递并计入重试次数. 不设置时为 DefaultSQLQueueVisibilityTimeout
+		VisibilityTimeout time.Duration
+		// ShutdownTimeout RunSubscriber 收到退出信号后等待正在处理的消息完成的时间. 不设置时为 DefaultShutdownTimeout
+		ShutdownTimeout time.Duration
+		// Unique WithUnique 使用的去重存储. 不设置时使用 NewMemoryDedupStore, 多副本部署时需要使用共享的存储
+		Unique DedupStore
+	}
+
+	// SQLQueue 基于 mysql 表的队列实现. 适用于只有 mysql 的服务, 与 RedisQueue 使用相同的选项以及语义.
+	// 消费者通过 SELECT ... FOR UPDATE SKIP LOCKED 轮询消息, 需要 mysql 8.0 及以上版本
+	SQLQueue struct {
+		db      *sql.DB
+		logger  logger.Logger
+		config  SQLQueueConfig
+		options []Option
+		router  *router
+
+		mu       sync.Mutex
+		started  bool
+		stopped  bool
+		stop     chan struct{}
+		workers  sync.WaitGroup
+		requeued atomic.Int64
+		// baseCtx 所有消息处理的 context 的父 context. 停止订阅超时时取消
+		baseCtx    context.Context
+		cancelBase context.CancelFunc
+	}
+
+	sqlExecer interface {
+		ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	}
+
+	sqlMessage struct {
+		id           int64
+		topic        string
+		payload      []byte
+		subscription string
+		// retried 取出消息之前已经处理的次数. 取出消息时表中的次数加一, 处理中的进程退出也会计入重试次数
+		retried     int
+		maxRetry    int
+		orderingKey string
+	}
+)
+
+func (c SQLQueueConfig) withDefaults() SQLQueueConfig {
+	if c.Table == "" {
+		c.Table = DefaultSQLQueueTable
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = runtime.NumCPU()
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = DefaultSQLQueuePollInterval
+	}
+	if c.VisibilityTimeout <= 0 {
+		c.VisibilityTimeout = DefaultSQLQueueVisibilityTimeout
+	}
+	if c.ShutdownTimeout <= 0 {
+		c.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if c.Unique == nil {
+		c.Unique = NewMemoryDedupStore()
+	}
+	return c
+}
+
+// Migrate 创建保存消息的表
+func (q *SQLQueue) Migrate(ctx context.Context) error {
+	return mysql.Migrate(ctx, q.db, fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` ("+
+		"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,"+
+		"`topic` VARCHAR(255) NOT NULL,"+
+		"`payload` MEDIUMBLOB NOT NULL,"+
+		"`subscription` VARCHAR(255) NOT NULL DEFAULT '',"+
+		"`ordering_key` VARCHAR(255) NOT NULL DEFAULT '',"+
+		"`retried` INT NOT NULL DEFAULT 0,"+
+		"`max_retry` INT NOT NULL DEFAULT 0,"+
+		"`last_error` TEXT NULL,"+
+		"`available_at` DATETIME(6) NOT NULL,"+
+		"`created_at` DATETIME(6) NOT NULL,"+
+		"PRIMARY KEY (`id`),"+
+		"KEY `idx_%s_available_at` (`available_at`),"+
+		"KEY `idx_%s_ordering` (`topic`, `subscription`, `ordering_key`, `id`)"+
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4", q.config.Table, q.config.Table, q.config.Table))
+}
+
+func (q *SQLQueue) Publish(ctx context.Context, topic string, message any, opts ...Option) error {
+	_, err := q.publish(ctx, q.db, topic, message, newOptions(mergeOptions(q.options, opts)))
+	return err
+}
+
+// PublishBatch 批量发布消息. 消息按照顺序依次写入
+func (q *SQLQueue) PublishBatch(ctx context.Context, topic string, messages []any, opts ...Option) ([]PublishResult, error) {
+	options := newOptions(mergeOptions(q.options, opts))
+	return publishBatch(ctx, messages, 1, func(ctx context.Context, message any) (string, error) {
+		return q.publish(ctx, q.db, topic, message, options)
+	})
+}
+
+func (q *SQLQueue) publish(ctx context.Context, execer sqlExecer, topic string, message any, options *options) (string, error) {
+	ctx, span := startPublishSpan(ctx, topic)
+	defer span.End()
+
+	messageID, data, err := encodeMessage(ctx, options, message)
+	if err != nil {
+		return "", err
+	}
+	release, err := acquireUnique(ctx, q.config.Unique, topic, options)
+	if err != nil {
+		recordPublish(ctx, topic, err)
+		return "", err
+	}
+	err = q.insert(ctx, execer, &sqlMessage{topic: topic, payload: data, maxRetry: options.maxRetry, orderingKey: options.orderingKey},
+		options.availableAt())
+	recordPublish(ctx, topic, err)
+	if err != nil {
+		release()
+		return "", err
+	}
+	q.logger.Info(ctx, "published message to sql queue success",
+		zap.ByteString("payload", data), zap.String("message_id", messageID))
+	return messageID, nil
+}
+
+func (q *SQLQueue) insert(ctx context.Context, execer sqlExecer, msg *sqlMessage, availableAt time.Time) error {
+	_, err := execer.ExecContext(ctx, fmt.Sprintf("INSERT INTO `%s` "+
+		"(`topic`, `payload`, `subscription`, `ordering_key`, `retried`, `max_retry`, `available_at`, `created_at`) "+
+		"VALUES (?, ?, ?, ?, 0, ?, ?, ?)", q.config.Table),
+		msg.topic, msg.payload, msg.subscription, msg.orderingKey, msg.maxRetry, availableAt.UTC(), time.Now().UTC())
+	return err
+}
+
+// SubscribeTo 注册订阅者. 与 RedisQueue 一样支持主题通配符以及 WithGroup 消费组
+func (q *SQLQueue) SubscribeTo(topic string, subscriber Subscriber, opts ...Option) {
+	q.router.add(topic, newSubscription(subscriber, newOptions(mergeOptions(q.options, opts))))
+}
+
+func (q *SQLQueue) StartSubscriber() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return ErrQueueClosed
+	}
+	if q.started {
+		return ErrSubscriberStarted
+	}
+	q.started = true
+	for i := 0; i < q.config.Concurrency; i++ {
+		q.workers.Add(1)
+		go q.work()
+	}
+	return nil
+}
+
+// RunSubscriber 同步启动订阅. 阻塞直到收到退出信号, 然后在 SQLQueueConfig.ShutdownTimeout 内停止订阅
+func (q *SQLQueue) RunSubscriber() error {
+	if err := q.StartSubscriber(); err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	<-signals
+
+	ctx, cancel := context.WithTimeout(context.Background(), q.config.ShutdownTimeout)
+	defer cancel()
+	return q.Shutdown(ctx)
+}
+
+// Shutdown 停止订阅. 停止轮询并等待正在处理的消息完成.
+// ctx 结束时取消正在处理的消息的 context, 被取消的消息会立即对其他消费者可见
+func (q *SQLQueue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		return nil
+	}
+	q.stopped = true
+	close(q.stop)
+	q.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(stopped)
+	}()
+	var err error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		q.cancelBase()
+		<-stopped
+		err = &ShutdownError{Requeued: int(q.requeued.Load()), Err: ctx.Err()}
+	}
+	q.cancelBase()
+	return err
+}
+
+// Close 数据库连接由调用方管理, 总是返回 nil
+func (q *SQLQueue) Close() error {
+	return nil
+}
+
+func (q *SQLQueue) work() {
+	defer q.workers.Done()
+	for {
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+		processed, err := q.processNext(q.baseCtx)
+		if err != nil {
+			q.logger.Error(q.baseCtx, "failed to poll sql queue", err)
+		}
+		if processed {
+			continue
+		}
+		select {
+		case <-q.stop:
+			return
+		case <-time.After(q.config.PollInterval):
+		}
+	}
+}
+
+// processNext 取出并处理一条消息. 没有可处理的消息时返回 false
+func (q *SQLQueue) processNext(ctx context.Context) (bool, error) {
+	msg, err := q.fetch(ctx)
+	if err != nil || msg == nil {
+		return false, err
+	}
+	q.dispatch(ctx, msg)
+	return true, nil
+}
+
+// fetch 锁定一条可处理的消息, 在可见性超时时间内对其他消费者隐藏, 并将处理次数加一.
+// 带有顺序键的消息只有在同一个订阅者的相同顺序键中没有更早发布的消息时才可被处理
+func (q *SQLQueue) fetch(ctx context.Context) (*sqlMessage, error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	msg := &sqlMessage{}
+	err = tx.QueryRowContext(ctx, fmt.Sprintf("SELECT m.`id`, m.`topic`, m.`payload`, m.`subscription`, m.`ordering_key`, m.`retried`, m.`max_retry` "+
+		"FROM `%s` AS m WHERE m.`available_at` <= ? AND (m.`ordering_key` = '' OR NOT EXISTS ("+
+		"SELECT 1 FROM `%s` AS o WHERE o.`topic` = m.`topic` AND o.`subscription` = m.`subscription` "+
+		"AND o.`ordering_key` = m.`ordering_key` AND o.`id` < m.`id`)) "+
+		"ORDER BY m.`available_at`, m.`id` LIMIT 1 FOR UPDATE SKIP LOCKED", q.config.Table, q.config.Table), now).
+		Scan(&msg.id, &msg.topic, &msg.payload, &msg.subscription, &msg.orderingKey, &msg.retried, &msg.maxRetry)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE `%s` SET `available_at` = ?, `retried` = `retried` + 1 WHERE `id` = ?", q.config.Table),
+		now.Add(q.config.VisibilityTimeout), msg.id); err != nil {
+		return nil, err
+	}
+	return msg, tx.Commit()
+}
+
+// dispatch 将消息投递给匹配的订阅者. 匹配到多个订阅者时为每个订阅者写入一个副本并删除原消息
+func (q *SQLQueue) dispatch(ctx context.Context, msg *sqlMessage) {
+	subscriptions := q.router.route(msg.topic, msg.subscription)
+	switch len(subscriptions) {
+	case 0:
+		err := fmt.Errorf("%w: %s", ErrNoSubscriber, msg.topic)
+		q.logger.Warn(ctx, "no subscriber for sql queue topic", zap.String("topic", msg.topic))
+		q.retry(ctx, msg, DefaultRetryBackoff(msg.retried, err), err)
+	case 1:
+		q.handle(ctx, msg, subscriptions[0])
+	default:
+		if err := q.fanOut(ctx, msg, subscriptions); err != nil {
+			q.logger.Error(ctx, "failed to fan out sql queue message", err, zap.String("topic", msg.topic))
+		}
+	}
+}
+
+func (q *SQLQueue) fanOut(ctx context.Context, msg *sqlMessage, subscriptions []*subscription) error {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, subscription := range subscriptions {
+		copied := &sqlMessage{topic: msg.topic, payload: msg.payload, subscription: subscription.key,
+			maxRetry: msg.maxRetry, orderingKey: msg.orderingKey}
+		if err := q.insert(ctx, tx, copied, time.Now()); err != nil {
+			return err
+		}
+	}
+	if err := q.delete(ctx, tx, msg); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (q *SQLQueue) handle(ctx context.Context, msg *sqlMessage, subscription *subscription) {
+	if msg.retried > 0 && subscription.exhausted(msg.retried-1, msg.maxRetry, nil) {
+		// 最后一次处理没有删除消息也没有重新投递, 处理中的进程退出. 不再处理避免消息一直导致进程退出
+		q.exhaust(ctx, msg, subscription, decodeEnvelope(msg.payload), errAttemptsExhausted)
+		return
+	}
+	consumeCtx, cancel := context.WithTimeout(ctx, q.config.VisibilityTimeout)
+	defer cancel()
+	consumeCtx, envelope, err := subscription.consume(consumeCtx, msg.topic, msg.payload)
+	// 处理完成后更新消息状态不受处理超时的影响
+	ctx = context.WithoutCancel(consumeCtx)
+	if err == nil || errors.Is(err, ErrMessageExpired) {
+		if errors.Is(err, ErrMessageExpired) {
+			q.logger.Warn(ctx, "sql queue message expired, message dropped",
+				zap.String("topic", msg.topic), zap.String("message_id", envelope.ID))
+		}
+		q.deleteMessage(ctx, msg)
+		return
+	}
+	if q.baseCtx.Err() != nil {
+		// 停止订阅超时被取消的消息立即重新可见, 不计入重试次数
+		q.requeued.Add(1)
+		q.retry(ctx, msg, 0, nil)
+		return
+	}
+	if errors.Is(err, errConcurrencyLimited) {
+		q.retry(ctx, msg, concurrencyRetryDelay, nil)
+		return
+	}
+	q.logger.Error(ctx, "failed to handle sql queue message", err,
+		zap.String("topic", msg.topic), zap.ByteString("payload", msg.payload), zap.Int("retried", msg.retried))
+
+	if !subscription.exhausted(msg.retried, msg.maxRetry, err) {
+		q.retry(ctx, msg, subscription.options.retryBackoff(msg.retried, err), err)
+		return
+	}
+	q.exhaust(ctx, msg, subscription, envelope, err)
+}
+
+// exhaust 将重试耗尽的消息投递到死信主题并删除. 投递死信与删除消息在同一个事务中完成
+func (q *SQLQueue) exhaust(ctx context.Context, msg *sqlMessage, subscription *subscription, envelope *envelope, err error) {
+	deadLetterTopic := subscription.deadLetterTopicOf(msg.topic, err)
+	if deadLetterTopic == "" {
+		q.logger.Warn(ctx, "sql queue message retry exhausted, message dropped",
+			zap.String("topic", msg.topic), zap.ByteString("payload", msg.payload))
+		q.deleteMessage(ctx, msg)
+		return
+	}
+	if dlqErr := q.publishDeadLetter(ctx, msg, deadLetterTopic, envelope, err); dlqErr != nil {
+		q.logger.Error(ctx, "failed to publish message to dead letter topic", dlqErr,
+			zap.String("topic", msg.topic), zap.String("dead_letter_topic", deadLetterTopic))
+		q.retry(ctx, msg, subscription.options.retryBackoff(msg.retried, err), err)
+	}
+}
+
+func (q *SQLQueue) publishDeadLetter(ctx context.Context, msg *sqlMessage, deadLetterTopic string, envelope *envelope, err error) error {
+	tx, txErr := q.db.BeginTx(ctx, nil)
+	if txErr != nil {
+		return txErr
+	}
+	defer tx.Rollback()
+	if _, txErr := q.publish(ctx, tx, deadLetterTopic, newDeadLetter(msg.topic, envelope, msg.retried, err),
+		newOptions(mergeOptions(q.options, []Option{WithCodec(BinaryCodec)}))); txErr != nil {
+		return txErr
+	}
+	if txErr := q.delete(ctx, tx, msg); txErr != nil {
+		return txErr
+	}
+	return tx.Commit()
+}
+
+// retry 在 backoff 后重新投递消息. err 为 nil 时不计入重试次数, 撤销取出消息时增加的处理次数
+func (q *SQLQueue) retry(ctx context.Context, msg *sqlMessage, backoff time.Duration, err error) {
+	query := fmt.Sprintf("UPDATE `%s` SET `available_at` = ?, `retried` = `retried` - 1 WHERE `id` = ?", q.config.Table)
+	args := []any{time.Now().Add(backoff).UTC(), msg.id}
+	if err != nil {
+		query = fmt.Sprintf("UPDATE `%s` SET `available_at` = ?, `last_error` = ? WHERE `id` = ?", q.config.Table)
+		args = []any{time.Now().Add(backoff).UTC(), err.Error(), msg.id}
+	}
+	if _, execErr := q.db.ExecContext(ctx, query, args...); execErr != nil {
+		q.logger.Error(ctx, "failed to retry sql queue message", execErr, zap.String("topic", msg.topic))
+	}
+}
+
+func (q *SQLQueue) deleteMessage(ctx context.Context, msg *sqlMessage) {
+	if err := q.delete(ctx, q.db, msg); err != nil {
+		q.logger.Error(ctx, "failed to delete sql queue message", err, zap.String("topic", msg.topic))
+	}
+}
+
+func (q *SQLQueue) delete(ctx context.Context, execer sqlExecer, msg *sqlMessage) error {
+	_, err := execer.ExecContext(ctx, fmt.Sprintf("DELETE FROM `%s` WHERE `id` = ?", q.config.Table), msg.id)
+	return err
+}
+
+// NewSQLQueue 创建基于 mysql 的队列. db 通常由 mysql.DB 创建, 使用前需要调用 Migrate 创建表.
+// opts 会作为该队列所有发布以及订阅的默认选项
+func NewSQLQueue(db *sql.DB, logger logger.Logger, config SQLQueueConfig, opts ...Option) *SQLQueue {
+	queue := &SQLQueue{
+		db:      db,
+		logger:  logger,
+		config:  config.withDefaults(),
+		options: opts,
+		router:  newRouter(),
+		stop:    make(chan struct{}),
+	}
+	queue.baseCtx, queue.cancelBase = context.WithCancel(context.Background())
+	return queue
+}
@@ -0,0 +1,144 @@
+package queue
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bmizerany/assert"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+func newMockSQLQueue(t *testing.T, opts ...Option) (*SQLQueue, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return NewSQLQueue(db, logger.NewNoopLogger(), SQLQueueConfig{}, opts...), mock
+}
+
+func expectFetch(mock sqlmock.Sqlmock, id int64, topic string, payload []byte, retried, maxRetry int) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM `queue_messages` AS m WHERE m.`available_at` <= ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "topic", "payload", "subscription", "ordering_key", "retried", "max_retry"}).
+			AddRow(id, topic, payload, "", "", retried, maxRetry))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `queue_messages` SET `available_at` = ?, `retried` = `retried` + 1 WHERE `id` = ?")).
+		WithArgs(sqlmock.AnyArg(), id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestSQLQueue_Publish(t *testing.T) {
+	queue, mock := newMockSQLQueue(t, WithCodec(JSONCodec))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `queue_messages`")).
+		WithArgs("order.created", sqlmock.AnyArg(), "", "order-1", 3, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := queue.Publish(context.Background(), "order.created", &orderCreated{ID: 1, Status: "created"},
+		WithMaxRetry(3), WithOrderingKey("order-1"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
+
+func TestSQLQueue_ProcessNext(t *testing.T) {
+	queue, mock := newMockSQLQueue(t, WithCodec(JSONCodec))
+	var received []orderCreated
+	queue.SubscribeTo("order.created", CreateSubscriber(func(ctx context.Context, topic string, message orderCreated) error {
+		received = append(received, message)
+		return nil
+	}))
+
+	expectFetch(mock, 1, "order.created", []byte(`{"id":1,"status":"created"}`), 0, DefaultMaxRetry)
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `queue_messages` WHERE `id` = ?")).
+		WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	processed, err := queue.processNext(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, processed)
+	assert.Equal(t, []orderCreated{{ID: 1, Status: "created"}}, received)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
+
+func TestSQLQueue_ProcessNextEmpty(t *testing.T) {
+	queue, mock := newMockSQLQueue(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FOR UPDATE SKIP LOCKED")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "topic", "payload", "subscription", "ordering_key", "retried", "max_retry"}))
+	mock.ExpectRollback()
+
+	processed, err := queue.processNext(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, processed)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
+
+func TestSQLQueue_RetryAndDeadLetter(t *testing.T) {
+	queue, mock := newMockSQLQueue(t)
+	queue.SubscribeTo("order.created", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		return errors.New("boom")
+	}), WithDeadLetter())
+
+	expectFetch(mock, 1, "order.created", []byte("payload"), 0, 1)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `queue_messages` SET `available_at` = ?, `last_error` = ? WHERE `id` = ?")).
+		WithArgs(sqlmock.AnyArg(), "boom", int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	processed, err := queue.processNext(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, processed)
+
+	expectFetch(mock, 1, "order.created", []byte("payload"), 1, 1)
+	expectDeadLetter(mock, 1, "order.created", "boom")
+	processed, err = queue.processNext(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, processed)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
+
+// expectDeadLetter 在同一个事务中写入死信并删除原消息
+func expectDeadLetter(mock sqlmock.Sqlmock, id int64, topic, err string) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `queue_messages`")).
+		WithArgs(DeadLetterTopic(topic), deadLetterErrorArg(err), "", "", DefaultMaxRetry, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(id+1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `queue_messages` WHERE `id` = ?")).
+		WithArgs(id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// deadLetterErrorArg 匹配死信中记录的错误
+type deadLetterErrorArg string
+
+func (a deadLetterErrorArg) Match(value driver.Value) bool {
+	data, ok := value.([]byte)
+	if !ok {
+		return false
+	}
+	deadLetter := &DeadLetter{}
+	return deadLetter.UnmarshalBinary(decodeEnvelope(data).Payload) == nil && deadLetter.Error == string(a)
+}
+
+func TestSQLQueue_AttemptsExhausted(t *testing.T) {
+	queue, mock := newMockSQLQueue(t)
+	calls := 0
+	queue.SubscribeTo("order.created", SubscriberFunc(func(ctx context.Context, topic string, message []byte) error {
+		calls++
+		return nil
+	}), WithDeadLetter())
+
+	// 最后一次处理时进程退出, 消息不再处理直接进入死信
+	expectFetch(mock, 1, "order.created", []byte("payload"), 2, 1)
+	expectDeadLetter(mock, 1, "order.created", errAttemptsExhausted.Error())
+	processed, err := queue.processNext(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, processed)
+	assert.Equal(t, 0, calls)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
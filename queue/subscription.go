@@ -0,0 +1,189 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/chaihaobo/gocommon/trace"
+)
+
+// ErrMessageExpired 消息超过 WithTTL 指定的有效期. 过期的消息不会投递给订阅者
+var ErrMessageExpired = errors.New("message expired")
+
+// errConcurrencyLimited 订阅者同时处理的消息达到 WithConcurrency 的限制. 消息会延迟后再次投递并且不计入重试次数,
+// 不会占用处理协程等待
+var errConcurrencyLimited = errors.New("subscriber concurrency limit reached")
+
+// concurrencyRetryDelay 达到并发限制的消息再次尝试投递的间隔
+const concurrencyRetryDelay = 100 * time.Millisecond
+
+// subscription 订阅者以及注册时的选项
+type subscription struct {
+	// pattern 订阅的主题模式
+	pattern string
+	// key 订阅者的标识. 由订阅的主题模式以及消费组组成
+	key        string
+	subscriber Subscriber
+	options    *options
+	// limiter 限制订阅者同时处理消息的数量. 未限制时为 nil
+	limiter chan struct{}
+}
+
+func newSubscription(subscriber Subscriber, options *options) *subscription {
+	subscription := &subscription{
+		subscriber: Chain(options.middlewares...)(subscriber),
+		options:    options,
+	}
+	if options.concurrency > 0 {
+		subscription.limiter = make(chan struct{}, options.concurrency)
+	}
+	return subscription
+}
+
+// consume 解析消息信封, 还原发布方的链路以及元数据后调用订阅者.
+// 返回的 context 携带了发布方的链路, 用于后续的重试以及死信处理
+func (s *subscription) consume(ctx context.Context, topic string, data []byte) (context.Context, *envelope, error) {
+	envelope := decodeEnvelope(data)
+	ctx = trace.Propagator.Extract(ctx, envelope.Metadata)
+	ctx = contextWithMetadata(ctx, envelope.Metadata)
+	ctx = contextWithMessageID(ctx, envelope.ID)
+	ctx = contextWithSubscription(ctx, s.key)
+	ctx = contextWithCodec(ctx, s.options.codec)
+	if envelope.expired() {
+		return ctx, envelope, ErrMessageExpired
+	}
+	if s.limiter != nil {
+		select {
+		case s.limiter <- struct{}{}:
+			defer func() { <-s.limiter }()
+		default:
+			return ctx, envelope, errConcurrencyLimited
+		}
+	}
+	startTime := time.Now()
+	invokeCtx, cancel := envelope.contextWithDeadline(ctx)
+	defer cancel()
+	err := s.invoke(invokeCtx, topic, envelope.Payload)
+	recordConsume(ctx, topic, startTime, envelope.AvailableAt, err)
+	return ctx, envelope, err
+}
+
+// invoke 调用订阅者. 订阅者 panic 时转换为错误返回
+func (s *subscription) invoke(ctx context.Context, topic string, payload []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return s.subscriber.Subscribe(ctx, topic, payload)
+}
+
+// exhausted 判断消息的重试次数是否已经耗尽. maxRetry 为发布消息时指定的最大重试次数. 无效的消息不再重试
+func (s *subscription) exhausted(retried, maxRetry int, err error) bool {
+	if errors.Is(err, ErrInvalidMessage) {
+		return true
+	}
+	if s.options.maxRetry < maxRetry {
+		maxRetry = s.options.maxRetry
+	}
+	return retried >= maxRetry
+}
+
+// deadLetterTopicOf 返回重试耗尽的消息的死信主题. 订阅者未开启死信时, 无效的消息仍然投递到 DeadLetterTopic(topic)
+func (s *subscription) deadLetterTopicOf(topic string, err error) string {
+	if deadLetterTopic := s.options.deadLetterTopicOf(topic); deadLetterTopic != "" {
+		return deadLetterTopic
+	}
+	if errors.Is(err, ErrInvalidMessage) {
+		return DeadLetterTopic(topic)
+	}
+	return ""
+}
+
+// encodeMessage 编码消息并将消息ID, 当前链路以及元数据写入消息信封. 返回消息ID以及信封
+func encodeMessage(ctx context.Context, options *options, message any) (string, []byte, error) {
+	envelope, err := newEnvelope(ctx, options, message)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := encodeEnvelope(envelope)
+	return envelope.ID, data, err
+}
+
+// newEnvelope 编码消息并生成消息信封
+func newEnvelope(ctx context.Context, options *options, message any) (*envelope, error) {
+	payload, err := options.codec.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	messageID := options.messageID
+	if messageID == "" {
+		messageID = uuid.NewString()
+	}
+	metadata := options.metadata.clone()
+	trace.Propagator.Inject(ctx, metadata)
+	availableAt := options.availableAt()
+	envelope := &envelope{
+		ID:          messageID,
+		Metadata:    metadata,
+		Payload:     payload,
+		AvailableAt: availableAt,
+		Deadline:    options.deadline,
+		Timeout:     options.timeout,
+		OrderingKey: options.orderingKey,
+	}
+	if options.ttl > 0 {
+		envelope.ExpiresAt = availableAt.Add(options.ttl)
+	}
+	return envelope, nil
+}
+
+// startPublishSpan 开启发布消息的链路. 订阅方的链路会成为其子链路
+func startPublishSpan(ctx context.Context, topic string) (context.Context, oteltrace.Span) {
+	return otel.Tracer(trace.DefaultTracerName).Start(ctx, "queue.publish."+topic,
+		oteltrace.WithSpanKind(oteltrace.SpanKindProducer))
+}
+
+// inflight 记录正在处理的消息数量. 用于停止订阅时等待正在处理的消息完成
+type inflight struct {
+	mu    sync.Mutex
+	count int
+	empty chan struct{}
+}
+
+func (i *inflight) add() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.count == 0 {
+		i.empty = make(chan struct{})
+	}
+	i.count++
+}
+
+func (i *inflight) done() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.count--
+	if i.count == 0 {
+		close(i.empty)
+	}
+}
+
+// idle 返回在没有正在处理的消息时关闭的 channel
+func (i *inflight) idle() <-chan struct{} {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.count == 0 {
+		empty := make(chan struct{})
+		close(empty)
+		return empty
+	}
+	return i.empty
+}
@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-playground/validator/v10"
+	"go.opentelemetry.io/otel"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/chaihaobo/gocommon/trace"
+)
+
+// ErrInvalidMessage 消息无法解码或者未通过校验. 订阅者返回该错误时消息不再重试, 直接投递到死信主题
+var ErrInvalidMessage = errors.New("invalid message")
+
+// defaultValidator WithValidation 未指定校验器时使用的校验器
+var defaultValidator = validator.New()
+
+type (
+	// Topic 绑定主题名称, 消息类型以及编解码器. 生产者与消费者共享同一个 Topic 的定义,
+	// 避免主题名称以及消息结构不一致
+	Topic[T any] struct {
+		name     string
+		codec    Codec
+		validate *validator.Validate
+	}
+
+	// TopicOption Topic 的选项
+	TopicOption func(*topicOptions)
+
+	topicOptions struct {
+		codec    Codec
+		validate *validator.Validate
+	}
+)
+
+// WithTopicCodec 指定主题的消息使用的编解码器. 默认为 BinaryCodec
+func WithTopicCodec(codec Codec) TopicOption {
+	return func(o *topicOptions) {
+		if codec != nil {
+			o.codec = codec
+		}
+	}
+}
+
+// WithValidation 使用 go-playground/validator 的 validate 标签校验消息. 发布时校验失败返回错误,
+// 订阅时校验失败的消息直接投递到死信主题. validate 为 nil 时使用默认的校验器
+func WithValidation(validate *validator.Validate) TopicOption {
+	return func(o *topicOptions) {
+		if validate == nil {
+			validate = defaultValidator
+		}
+		o.validate = validate
+	}
+}
+
+// Name 返回主题名称
+func (t *Topic[T]) Name() string {
+	return t.name
+}
+
+// Publish 校验并发布消息. 消息使用主题的编解码器编码
+func (t *Topic[T]) Publish(ctx context.Context, queue Queue, message T, opts ...Option) error {
+	if err := t.validateMessage(message); err != nil {
+		return err
+	}
+	return queue.Publish(ctx, t.name, message, t.options(opts)...)
+}
+
+// Subscribe 注册主题的订阅者. 消息使用主题的编解码器解码, 无法解码或者未通过校验的消息不会重试,
+// 直接投递到订阅者的死信主题, 订阅者未开启死信时投递到 DeadLetterTopic(主题名称)
+func (t *Topic[T]) Subscribe(queue Queue, handleFunc func(ctx context.Context, message T) error, opts ...Option) {
+	queue.SubscribeTo(t.name, SubscriberFunc(func(ctx context.Context, topic string, payload []byte) error {
+		ctx, span := otel.Tracer(trace.DefaultTracerName).Start(ctx, "queue.subscribe.consume."+topic,
+			oteltrace.WithSpanKind(oteltrace.SpanKindConsumer))
+		defer span.End()
+
+		message, err := decodeMessage[T](t.codec, payload)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+		}
+		if err := t.validateMessage(message); err != nil {
+			return err
+		}
+		return handleFunc(ctx, message)
+	}), t.options(opts)...)
+}
+
+// options 在调用方的选项之后追加主题的编解码器, 主题的编解码器优先
+func (t *Topic[T]) options(opts []Option) []Option {
+	return append(opts[:len(opts):len(opts)], WithCodec(t.codec))
+}
+
+func (t *Topic[T]) validateMessage(message T) error {
+	if t.validate == nil {
+		return nil
+	}
+	err := t.validate.Struct(message)
+	var invalidValidationErr *validator.InvalidValidationError
+	if err == nil || errors.As(err, &invalidValidationErr) {
+		// 消息不是结构体时不校验
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+}
+
+// NewTopic 创建主题
+func NewTopic[T any](name string, opts ...TopicOption) *Topic[T] {
+	options := &topicOptions{codec: BinaryCodec}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return &Topic[T]{
+		name:     name,
+		codec:    options.codec,
+		validate: options.validate,
+	}
+}
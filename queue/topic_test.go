@@ -0,0 +1,47 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bmizerany/assert"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+type orderShipped struct {
+	ID      int64  `json:"id" validate:"required"`
+	Carrier string `json:"carrier" validate:"required"`
+}
+
+func TestTopic(t *testing.T) {
+	queue := NewMemoryQueue(logger.NewNoopLogger(), 1)
+	topic := NewTopic[*orderShipped]("order.shipped", WithTopicCodec(JSONCodec), WithValidation(nil))
+	received := make(chan *orderShipped, 1)
+	topic.Subscribe(queue, func(ctx context.Context, message *orderShipped) error {
+		received <- message
+		return nil
+	})
+	deadLetters := make(chan *DeadLetter, 1)
+	queue.SubscribeTo(DeadLetterTopic("order.shipped"), CreateSubscriber(func(ctx context.Context, topic string, message *DeadLetter) error {
+		deadLetters <- message
+		return nil
+	}))
+	assert.Equal(t, nil, queue.StartSubscriber())
+	defer queue.Shutdown(context.Background())
+
+	ctx := context.Background()
+	assert.Equal(t, "order.shipped", topic.Name())
+	assert.Equal(t, nil, topic.Publish(ctx, queue, &orderShipped{ID: 1, Carrier: "ups"}))
+	assert.Equal(t, &orderShipped{ID: 1, Carrier: "ups"}, waitMessage(t, received))
+
+	err := topic.Publish(ctx, queue, &orderShipped{ID: 2})
+	assert.T(t, errors.Is(err, ErrInvalidMessage))
+
+	// 绕过 Topic 发布的无效消息不重试, 直接进入死信
+	assert.Equal(t, nil, queue.Publish(ctx, "order.shipped", []byte(`{"id":3}`)))
+	deadLetter := waitMessage(t, deadLetters)
+	assert.Equal(t, `{"id":3}`, string(deadLetter.Payload))
+	assert.Equal(t, 0, deadLetter.Retried)
+}
@@ -0,0 +1,252 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+type (
+	// StateMachineDefinition 状态机的定义. 包含初始状态, 终止状态以及所有的状态转换.
+	// 定义完成后可以为多个状态持有者创建状态机, 并且可以校验状态图以及导出为 DOT/Mermaid 图.
+	// 第一次通过 Submit 或者 NewStateMachine 使用之后定义不可再修改, 可以被多个 goroutine 并发使用
+	StateMachineDefinition[T StateHolder[S], S comparable] struct {
+		sealed      atomic.Bool
+		initial     S
+		finals      []S
+		actions     []string
+		transitions map[string]*Transition[T, S]
+		duplicates  []string
+		recorders   []TransitionRecorder[T, S]
+		onEnter     map[S][]ActionHandler[T, S]
+		onExit      map[S][]ActionHandler[T, S]
+	}
+
+	// DefinitionError 状态机的定义校验失败
+	DefinitionError[S comparable] struct {
+		// UnreachableStates 从初始状态无法到达的状态
+		UnreachableStates []S
+		// DeadEnds 没有任何动作可以执行并且不是终止状态的状态
+		DeadEnds []S
+		// DuplicateActions 重复定义的动作
+		DuplicateActions []string
+	}
+)
+
+func (e *DefinitionError[S]) Error() string {
+	problems := make([]string, 0, 3)
+	if len(e.UnreachableStates) > 0 {
+		problems = append(problems, fmt.Sprintf("unreachable states %v", e.UnreachableStates))
+	}
+	if len(e.DeadEnds) > 0 {
+		problems = append(problems, fmt.Sprintf("dead end states %v", e.DeadEnds))
+	}
+	if len(e.DuplicateActions) > 0 {
+		problems = append(problems, fmt.Sprintf("duplicate actions %v", e.DuplicateActions))
+	}
+	return "invalid state machine definition: " + strings.Join(problems, ", ")
+}
+
+// AddTransition 添加状态转换的映射. 重复定义的动作会覆盖之前的定义, 并且在 Validate 时报告
+func (d *StateMachineDefinition[T, S]) AddTransition(action string, transition *Transition[T, S]) *StateMachineDefinition[T, S] {
+	d.mustNotSealed()
+	if _, ok := d.transitions[action]; ok {
+		d.duplicates = append(d.duplicates, action)
+	} else {
+		d.actions = append(d.actions, action)
+	}
+	d.transitions[action] = transition
+	return d
+}
+
+// Final 指定终止状态. 终止状态没有可以执行的动作, 不会被 Validate 报告为死胡同
+func (d *StateMachineDefinition[T, S]) Final(states ...S) *StateMachineDefinition[T, S] {
+	d.mustNotSealed()
+	d.finals = append(d.finals, states...)
+	return d
+}
+
+// AddRecorder 添加状态转换的记录器. 每次执行动作的处理函数后按照添加的顺序调用
+func (d *StateMachineDefinition[T, S]) AddRecorder(recorders ...TransitionRecorder[T, S]) *StateMachineDefinition[T, S] {
+	d.mustNotSealed()
+	d.recorders = append(d.recorders, recorders...)
+	return d
+}
+
+// OnEnter 添加进入状态时的回调. 执行动作后状态发生变化时调用. 用于将通知等副作用关联到状态而不是每一个状态转换
+func (d *StateMachineDefinition[T, S]) OnEnter(state S, callback ActionHandler[T, S]) *StateMachineDefinition[T, S] {
+	d.mustNotSealed()
+	d.onEnter[state] = append(d.onEnter[state], callback)
+	return d
+}
+
+// OnExit 添加离开状态时的回调. 执行动作后状态发生变化时调用, 在进入新状态的回调之前
+func (d *StateMachineDefinition[T, S]) OnExit(state S, callback ActionHandler[T, S]) *StateMachineDefinition[T, S] {
+	d.mustNotSealed()
+	d.onExit[state] = append(d.onExit[state], callback)
+	return d
+}
+
+// NewStateMachine 使用定义为状态持有者创建状态机. 多个状态机共享同一个定义
+func (d *StateMachineDefinition[T, S]) NewStateMachine(stateHolder T) StateMachine[T, S] {
+	d.sealed.Store(true)
+	return StateMachine[T, S]{
+		stateHolder: stateHolder,
+		definition:  d,
+	}
+}
+
+// Submit 对状态持有者执行一个动作. 与 StateMachine.Submit 相同, 不需要为每个状态持有者创建状态机
+func (d *StateMachineDefinition[T, S]) Submit(ctx context.Context, stateHolder T, action string) error {
+	machine := d.NewStateMachine(stateHolder)
+	return machine.Submit(ctx, action)
+}
+
+// Submits 对状态持有者执行多个连续的动作
+func (d *StateMachineDefinition[T, S]) Submits(ctx context.Context, stateHolder T, actions ...string) error {
+	machine := d.NewStateMachine(stateHolder)
+	return machine.Submits(ctx, actions...)
+}
+
+// mustNotSealed 定义被使用之后修改定义会与并发执行的状态转换产生数据竞争
+func (d *StateMachineDefinition[T, S]) mustNotSealed() {
+	if d.sealed.Load() {
+		panic("statemachine: definition can not be modified after it is used")
+	}
+}
+
+// Validate 校验状态图. 存在从初始状态无法到达的状态, 死胡同或者重复定义的动作时返回 *DefinitionError
+func (d *StateMachineDefinition[T, S]) Validate() error {
+	states := d.states()
+	reachable := map[S]bool{d.initial: true}
+	queue := []S{d.initial}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for _, action := range d.actions {
+			transition := d.transitions[action]
+			if !transition.allowFrom(state) {
+				continue
+			}
+			for _, next := range transition.targets() {
+				if !reachable[next] {
+					reachable[next] = true
+					queue = append(queue, next)
+				}
+			}
+		}
+	}
+
+	result := &DefinitionError[S]{DuplicateActions: d.duplicates}
+	for _, state := range states {
+		if !reachable[state] {
+			result.UnreachableStates = append(result.UnreachableStates, state)
+		}
+		if !slices.Contains(d.finals, state) && !d.hasAction(state) {
+			result.DeadEnds = append(result.DeadEnds, state)
+		}
+	}
+	if len(result.UnreachableStates) == 0 && len(result.DeadEnds) == 0 && len(result.DuplicateActions) == 0 {
+		return nil
+	}
+	return result
+}
+
+// ExportDOT 将状态图导出为 graphviz 的 DOT 格式. 失败的状态转换使用虚线表示
+func (d *StateMachineDefinition[T, S]) ExportDOT() string {
+	var builder strings.Builder
+	builder.WriteString("digraph StateMachine {\n")
+	builder.WriteString("  rankdir=LR;\n")
+	builder.WriteString(fmt.Sprintf("  %q [shape=circle, style=bold];\n", fmt.Sprint(d.initial)))
+	for _, final := range d.finals {
+		builder.WriteString(fmt.Sprintf("  %q [shape=doublecircle];\n", fmt.Sprint(final)))
+	}
+	d.eachEdge(func(from, to S, label string, failed bool) {
+		style := ""
+		if failed {
+			style = ", style=dashed"
+		}
+		builder.WriteString(fmt.Sprintf("  %q -> %q [label=%q%s];\n", fmt.Sprint(from), fmt.Sprint(to), label, style))
+	})
+	builder.WriteString("}\n")
+	return builder.String()
+}
+
+// ExportMermaid 将状态图导出为 Mermaid 的 stateDiagram-v2 格式
+func (d *StateMachineDefinition[T, S]) ExportMermaid() string {
+	var builder strings.Builder
+	builder.WriteString("stateDiagram-v2\n")
+	builder.WriteString(fmt.Sprintf("    [*] --> %v\n", d.initial))
+	d.eachEdge(func(from, to S, label string, failed bool) {
+		builder.WriteString(fmt.Sprintf("    %v --> %v : %s\n", from, to, label))
+	})
+	for _, final := range d.finals {
+		builder.WriteString(fmt.Sprintf("    %v --> [*]\n", final))
+	}
+	return builder.String()
+}
+
+// eachEdge 按照动作定义的顺序遍历状态图中的边. 边的标签为动作以及前置条件, 失败的状态转换的标签以 failed 结尾
+func (d *StateMachineDefinition[T, S]) eachEdge(fn func(from, to S, label string, failed bool)) {
+	for _, action := range d.actions {
+		transition := d.transitions[action]
+		label := action
+		if len(transition.guards) > 0 {
+			names := make([]string, 0, len(transition.guards))
+			for _, guard := range transition.guards {
+				names = append(names, guard.name)
+			}
+			label += " [" + strings.Join(names, ", ") + "]"
+		}
+		for _, from := range transition.sources() {
+			fn(from, transition.to, label, false)
+			if transition.hasFailed {
+				fn(from, transition.failed, label+" failed", true)
+			}
+		}
+	}
+}
+
+// states 返回状态图中所有的状态, 按照出现的顺序排列
+func (d *StateMachineDefinition[T, S]) states() []S {
+	states := []S{d.initial}
+	add := func(state S) {
+		if !slices.Contains(states, state) {
+			states = append(states, state)
+		}
+	}
+	for _, action := range d.actions {
+		transition := d.transitions[action]
+		for _, from := range transition.sources() {
+			add(from)
+		}
+		for _, target := range transition.targets() {
+			add(target)
+		}
+	}
+	for _, final := range d.finals {
+		add(final)
+	}
+	return states
+}
+
+func (d *StateMachineDefinition[T, S]) hasAction(state S) bool {
+	for _, transition := range d.transitions {
+		if transition.allowFrom(state) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewStateMachineDefinition 创建状态机的定义. initial 为状态持有者的初始状态
+func NewStateMachineDefinition[T StateHolder[S], S comparable](initial S) *StateMachineDefinition[T, S] {
+	return &StateMachineDefinition[T, S]{
+		initial:     initial,
+		transitions: make(map[string]*Transition[T, S]),
+		onEnter:     make(map[S][]ActionHandler[T, S]),
+		onExit:      make(map[S][]ActionHandler[T, S]),
+	}
+}
@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func newOrderDefinition() *StateMachineDefinition[*order, string] {
+	return NewStateMachineDefinition[*order, string]("created").
+		AddTransition("pay", NewTransitionBuilder[*order, string]().
+			From("created").To("paid").Failed("pay_failed").Build()).
+		AddTransition("retry_pay", NewTransitionBuilder[*order, string]().
+			From("pay_failed").To("paid").Build()).
+		AddTransition("ship", NewTransitionBuilder[*order, string]().
+			From("paid").To("shipped").Build()).
+		Final("shipped")
+}
+
+func TestStateMachineDefinition_Validate(t *testing.T) {
+	assert.Equal(t, nil, newOrderDefinition().Validate())
+
+	definition := newOrderDefinition().
+		AddTransition("ship", NewTransitionBuilder[*order, string]().
+			From("paid").To("shipped").Build()).
+		AddTransition("refund", NewTransitionBuilder[*order, string]().
+			From("cancelled").To("refunded").Build())
+	err := definition.Validate()
+	var definitionErr *DefinitionError[string]
+	assert.T(t, errors.As(err, &definitionErr))
+	assert.Equal(t, []string{"cancelled", "refunded"}, definitionErr.UnreachableStates)
+	assert.Equal(t, []string{"refunded"}, definitionErr.DeadEnds)
+	assert.Equal(t, []string{"ship"}, definitionErr.DuplicateActions)
+}
+
+func TestStateMachineDefinition_Export(t *testing.T) {
+	definition := newOrderDefinition()
+	assert.Equal(t, `digraph StateMachine {
+  rankdir=LR;
+  "created" [shape=circle, style=bold];
+  "shipped" [shape=doublecircle];
+  "created" -> "paid" [label="pay"];
+  "created" -> "pay_failed" [label="pay failed", style=dashed];
+  "pay_failed" -> "paid" [label="retry_pay"];
+  "paid" -> "shipped" [label="ship"];
+}
+`, definition.ExportDOT())
+	assert.Equal(t, `stateDiagram-v2
+    [*] --> created
+    created --> paid : pay
+    created --> pay_failed : pay failed
+    pay_failed --> paid : retry_pay
+    paid --> shipped : ship
+    shipped --> [*]
+`, definition.ExportMermaid())
+}
+
+func TestStateMachineDefinition_Submit(t *testing.T) {
+	definition := newOrderDefinition()
+	var wg sync.WaitGroup
+	orders := make([]*order, 8)
+	for i := range orders {
+		orders[i] = &order{ID: int64(i), Status: "created"}
+		wg.Add(1)
+		go func(o *order) {
+			defer wg.Done()
+			assert.Equal(t, nil, definition.Submits(context.Background(), o, "pay", "ship"))
+		}(orders[i])
+	}
+	wg.Wait()
+	for _, o := range orders {
+		assert.Equal(t, "shipped", o.Status)
+	}
+
+	defer func() {
+		assert.NotEqual(t, nil, recover())
+	}()
+	definition.Final("cancelled")
+}
@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+type (
+	// TransitionAudit 状态转换的审计记录
+	TransitionAudit struct {
+		ID uint64 `gorm:"primaryKey;autoIncrement"`
+		// Subject 状态持有者的标识, 例如订单号
+		Subject   string `gorm:"size:128;not null;index"`
+		Action    string `gorm:"size:64;not null"`
+		FromState string `gorm:"size:64;not null"`
+		ToState   string `gorm:"size:64;not null"`
+		Error     string `gorm:"type:text"`
+		Actor     string `gorm:"size:128"`
+		StartedAt time.Time
+		// DurationMs 执行动作的处理函数的耗时, 单位为毫秒
+		DurationMs int64
+		CreatedAt  time.Time
+	}
+
+	// GormAuditRecorder 将状态转换写入 mysql 的审计表. 写入失败时只记录日志
+	GormAuditRecorder[T StateHolder[S], S comparable] struct {
+		db      *gorm.DB
+		logger  logger.Logger
+		subject func(T) string
+	}
+)
+
+func (TransitionAudit) TableName() string {
+	return "state_transition_audit"
+}
+
+// AutoMigrate 创建或者更新审计表
+func (r *GormAuditRecorder[T, S]) AutoMigrate(ctx context.Context) error {
+	return r.db.WithContext(ctx).AutoMigrate(&TransitionAudit{})
+}
+
+func (r *GormAuditRecorder[T, S]) Record(ctx context.Context, record TransitionRecord[T, S]) {
+	audit := &TransitionAudit{
+		Subject:    r.subject(record.StateHolder),
+		Action:     record.Action,
+		FromState:  fmt.Sprint(record.From),
+		ToState:    fmt.Sprint(record.To),
+		Actor:      record.Actor,
+		StartedAt:  record.StartedAt,
+		DurationMs: record.Duration.Milliseconds(),
+	}
+	if record.Err != nil {
+		audit.Error = record.Err.Error()
+	}
+	if err := r.db.WithContext(context.WithoutCancel(ctx)).Create(audit).Error; err != nil {
+		r.logger.Error(ctx, "failed to write state transition audit", err,
+			zap.String("subject", audit.Subject), zap.String("action", audit.Action))
+	}
+}
+
+// NewGormAuditRecorder 创建写入审计表的记录器. subject 返回状态持有者的标识
+func NewGormAuditRecorder[T StateHolder[S], S comparable](db *gorm.DB, logger logger.Logger, subject func(T) string) *GormAuditRecorder[T, S] {
+	return &GormAuditRecorder[T, S]{
+		db:      db,
+		logger:  logger,
+		subject: subject,
+	}
+}
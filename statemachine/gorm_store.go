@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"context"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultVersionColumn 默认的版本号列名
+const DefaultVersionColumn = "version"
+
+var _ StateStore[VersionedStateHolder[string], string] = (*GormStateStore[VersionedStateHolder[string], string])(nil)
+
+type (
+	// GormStateStore 基于 gorm 的状态持有者存储. T 必须为 gorm 模型的指针类型.
+	// 保存时只有数据库中的版本号与加载时一致才会更新, 更新成功后版本号加一
+	GormStateStore[T VersionedStateHolder[S], S comparable] struct {
+		db            *gorm.DB
+		versionColumn string
+	}
+
+	GormStateStoreOption func(*gormStateStoreOptions)
+
+	gormStateStoreOptions struct {
+		versionColumn string
+	}
+)
+
+// WithVersionColumn 指定版本号的列名. 默认为 DefaultVersionColumn
+func WithVersionColumn(column string) GormStateStoreOption {
+	return func(o *gormStateStoreOptions) {
+		o.versionColumn = column
+	}
+}
+
+// Load 按照主键加载状态持有者
+func (g *GormStateStore[T, S]) Load(ctx context.Context, id any) (T, error) {
+	var stateHolder T
+	stateHolder = reflect.New(reflect.TypeOf(stateHolder).Elem()).Interface().(T)
+	statement := &gorm.Statement{DB: g.db}
+	if err := statement.Parse(stateHolder); err != nil {
+		return stateHolder, err
+	}
+	primaryKey := statement.Schema.PrioritizedPrimaryField
+	if primaryKey == nil {
+		return stateHolder, gorm.ErrMissingWhereClause
+	}
+	err := g.db.WithContext(ctx).
+		Where(clause.Eq{Column: clause.Column{Name: primaryKey.DBName}, Value: id}).
+		Take(stateHolder).Error
+	return stateHolder, err
+}
+
+// Save 保存状态持有者的所有字段. 版本号与加载时不一致时返回 ErrConcurrentTransition
+func (g *GormStateStore[T, S]) Save(ctx context.Context, stateHolder T) error {
+	version := stateHolder.StateVersion()
+	stateHolder.SetStateVersion(version + 1)
+	result := g.db.WithContext(ctx).Model(stateHolder).
+		Where(clause.Eq{Column: clause.Column{Name: g.versionColumn}, Value: version}).
+		Select("*").
+		Updates(stateHolder)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = ErrConcurrentTransition
+	}
+	if result.Error != nil {
+		stateHolder.SetStateVersion(version)
+	}
+	return result.Error
+}
+
+func NewGormStateStore[T VersionedStateHolder[S], S comparable](db *gorm.DB, opts ...GormStateStoreOption) *GormStateStore[T, S] {
+	options := &gormStateStoreOptions{versionColumn: DefaultVersionColumn}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return &GormStateStore[T, S]{
+		db:            db,
+		versionColumn: options.versionColumn,
+	}
+}
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/chaihaobo/gocommon/logger"
+)
+
+type (
+	// TransitionRecord 一次状态转换的记录
+	TransitionRecord[T StateHolder[S], S comparable] struct {
+		StateHolder T
+		Action      string
+		From        S
+		// To 执行动作后的状态. 处理函数失败时为 failed 状态
+		To S
+		// Err 处理函数返回的错误
+		Err error
+		// Actor 执行动作的操作者. 通过 ContextWithActor 指定
+		Actor     string
+		StartedAt time.Time
+		Duration  time.Duration
+	}
+
+	// TransitionRecorder 状态转换的记录器. 记录失败不影响状态转换, 记录器需要自行处理错误
+	TransitionRecorder[T StateHolder[S], S comparable] interface {
+		Record(ctx context.Context, record TransitionRecord[T, S])
+	}
+
+	TransitionRecorderFunc[T StateHolder[S], S comparable] func(context.Context, TransitionRecord[T, S])
+
+	// LoggerRecorder 将状态转换写入日志. 处理函数失败时使用 Error 级别
+	LoggerRecorder[T StateHolder[S], S comparable] struct {
+		logger logger.Logger
+	}
+
+	// MemoryRecorder 在内存中保存所有的状态转换. 适用于单元测试
+	MemoryRecorder[T StateHolder[S], S comparable] struct {
+		mu      sync.Mutex
+		records []TransitionRecord[T, S]
+	}
+
+	actorContextKey struct{}
+)
+
+func (r TransitionRecorderFunc[T, S]) Record(ctx context.Context, record TransitionRecord[T, S]) {
+	r(ctx, record)
+}
+
+// ContextWithActor 指定执行动作的操作者
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// ActorFromContext 返回执行动作的操作者. 未指定时返回空字符串
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorContextKey{}).(string)
+	return actor
+}
+
+func (r *LoggerRecorder[T, S]) Record(ctx context.Context, record TransitionRecord[T, S]) {
+	fields := []zap.Field{
+		zap.String("action", record.Action),
+		zap.String("from", fmt.Sprint(record.From)),
+		zap.String("to", fmt.Sprint(record.To)),
+		zap.String("actor", record.Actor),
+		zap.Duration("duration", record.Duration),
+	}
+	if record.Err != nil {
+		r.logger.Error(ctx, "state transition failed", record.Err, fields...)
+		return
+	}
+	r.logger.Info(ctx, "state transition succeeded", fields...)
+}
+
+func NewLoggerRecorder[T StateHolder[S], S comparable](logger logger.Logger) *LoggerRecorder[T, S] {
+	return &LoggerRecorder[T, S]{logger: logger}
+}
+
+func (r *MemoryRecorder[T, S]) Record(ctx context.Context, record TransitionRecord[T, S]) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, record)
+}
+
+// Records 返回已经记录的状态转换
+func (r *MemoryRecorder[T, S]) Records() []TransitionRecord[T, S] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]TransitionRecord[T, S](nil), r.records...)
+}
+
+// Reset 清空已经记录的状态转换
+func (r *MemoryRecorder[T, S]) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = nil
+}
+
+func NewMemoryRecorder[T StateHolder[S], S comparable]() *MemoryRecorder[T, S] {
+	return &MemoryRecorder[T, S]{}
+}
@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestStateMachine_Recorder(t *testing.T) {
+	recorder := NewMemoryRecorder[*order, string]()
+	o := &order{ID: 1, Status: "created"}
+	machine := NewStateMachine[*order, string](o)
+	machine.AddRecorder(recorder).
+		AddTransition("pay", NewTransitionBuilder[*order, string]().
+			From("created").To("paid").Failed("pay_failed").Build()).
+		AddTransition("ship", NewTransitionBuilder[*order, string]().
+			From("paid").To("shipped").Failed("ship_failed").
+			Handler(ActionHandlerFunc[*order, string](func(ctx context.Context, o *order) error {
+				return errors.New("no carrier")
+			})).Build())
+
+	ctx := ContextWithActor(context.Background(), "alice")
+	assert.NotEqual(t, nil, machine.Submits(ctx, "pay", "ship"))
+
+	records := recorder.Records()
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, "pay", records[0].Action)
+	assert.Equal(t, "created", records[0].From)
+	assert.Equal(t, "paid", records[0].To)
+	assert.Equal(t, nil, records[0].Err)
+	assert.Equal(t, "alice", records[0].Actor)
+	assert.Equal(t, "ship", records[1].Action)
+	assert.Equal(t, "ship_failed", records[1].To)
+	assert.Equal(t, "no carrier", records[1].Err.Error())
+	assert.Equal(t, o, records[1].StateHolder)
+}
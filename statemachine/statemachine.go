@@ -4,18 +4,29 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 )
 
 var (
 	ErrActionNotDefine = errors.New("action not defined")
 )
 
+// ErrGuardRejected 执行动作的前置条件不满足
+type ErrGuardRejected struct {
+	Action string
+	Guard  string
+}
+
+func (e *ErrGuardRejected) Error() string {
+	return fmt.Sprintf("guard %s rejected action %s", e.Guard, e.Action)
+}
+
 type (
 	// StateMachine 状态机 执行一个动作. 完成一个状态到另外一个状态的转换
 	// T StateHolder[S] T:状态持有者 S:持有的状态
 	StateMachine[T StateHolder[S], S comparable] struct {
 		stateHolder T
-		transitions map[string]*Transition[T, S]
+		definition  *StateMachineDefinition[T, S]
 	}
 
 	StateHolder[S comparable] interface {
@@ -24,52 +35,140 @@ type (
 	}
 )
 
-// AddTransition 添加状态转换的映射. 定义了一条规则: 执行一个动作需要的状态为from, 执行动作为action, 执行动作成功后状态为to, 执行动作失败后状态为failed
+// AddTransition 添加状态转换的映射. 定义了一条规则: 执行一个动作需要的状态为from中的任意一个, 执行动作为action, 执行动作成功后状态为to, 执行动作失败后状态为failed
 // action 执行的动作
 // from 执行动作前的状态
 // to 执行动作后的状态
 // failed 执行动作失败后的状态
+// guards 执行动作前需要满足的前置条件
+// beforeHooks 执行动作的处理函数之前需要执行的钩子函数
 // handler 执行动作的处理函数
-// afterHooks 执行动作成功后需要执行的钩子函数
+// successHooks 执行动作成功后需要执行的钩子函数
+// failureHooks 执行动作失败后需要执行的钩子函数
+// afterHooks 执行动作后需要执行的钩子函数, 无论成功或者失败
 func (s *StateMachine[T, S]) AddTransition(action string, transition *Transition[T, S]) *StateMachine[T, S] {
-	s.transitions[action] = transition
+	s.definition.AddTransition(action, transition)
+	return s
+}
+
+// AddRecorder 添加状态转换的记录器. 每次执行动作的处理函数后按照添加的顺序调用
+func (s *StateMachine[T, S]) AddRecorder(recorders ...TransitionRecorder[T, S]) *StateMachine[T, S] {
+	s.definition.AddRecorder(recorders...)
+	return s
+}
+
+// OnEnter 添加进入状态时的回调. 执行动作后状态发生变化时调用
+func (s *StateMachine[T, S]) OnEnter(state S, callback ActionHandler[T, S]) *StateMachine[T, S] {
+	s.definition.OnEnter(state, callback)
+	return s
+}
+
+// OnExit 添加离开状态时的回调. 执行动作后状态发生变化时调用, 在进入新状态的回调之前
+func (s *StateMachine[T, S]) OnExit(state S, callback ActionHandler[T, S]) *StateMachine[T, S] {
+	s.definition.OnExit(state, callback)
 	return s
 }
 
 func NewStateMachine[T StateHolder[S], S comparable](stateHolder T) StateMachine[T, S] {
+	var initial S
 	return StateMachine[T, S]{
 		stateHolder: stateHolder,
-		transitions: make(map[string]*Transition[T, S]),
+		definition:  NewStateMachineDefinition[T, S](initial),
 	}
 }
 
 // Submit 执行一个动作。完成一个状态到另外一个状态的转换
+// 执行顺序: 前置条件, beforeHooks, 处理函数, 更新状态, 离开以及进入状态的回调, successHooks 或者 failureHooks, afterHooks
 func (s *StateMachine[T, S]) Submit(ctx context.Context, action string) error {
-	transition, ok := s.transitions[action]
+	transition, err := s.transition(ctx, action)
+	if err != nil {
+		return err
+	}
+	if err := s.invokeHooks(ctx, "before hook", transition.beforeHooks); err != nil {
+		return err
+	}
+	from, startedAt := s.stateHolder.State(), time.Now()
+	err = s.transit(ctx, transition)
+	s.record(ctx, action, from, startedAt, err)
+	return s.complete(ctx, action, from, transition, err)
+}
+
+// transition 返回当前状态下可以执行的动作的状态转换. 检查执行动作需要的状态以及前置条件
+func (s *StateMachine[T, S]) transition(ctx context.Context, action string) (*Transition[T, S], error) {
+	transition, ok := s.definition.transitions[action]
 	if !ok {
-		return ErrActionNotDefine
+		return nil, ErrActionNotDefine
 	}
-	fromState := transition.from
-	if currentState := s.stateHolder.State(); fromState != currentState {
-		return fmt.Errorf("current state is %v, can not execute this action %s", currentState, action)
+	if currentState := s.stateHolder.State(); !transition.allowFrom(currentState) {
+		return nil, fmt.Errorf("current state is %v, can not execute this action %s", currentState, action)
 	}
+	if err := transition.checkGuards(ctx, action, s.stateHolder); err != nil {
+		return nil, err
+	}
+	return transition, nil
+}
+
+// transit 执行动作的处理函数并更新状态. 返回处理函数的错误
+func (s *StateMachine[T, S]) transit(ctx context.Context, transition *Transition[T, S]) error {
 	err := transition.getHandler().Invoke(ctx, s.stateHolder)
 	if err != nil {
 		s.stateHolder.UpdateState(transition.failed, err)
-		if err := s.triggerAfterHook(ctx, transition); err != nil {
+		return err
+	}
+	s.stateHolder.UpdateState(transition.to, nil)
+	return nil
+}
+
+// complete 状态更新后执行状态的回调以及状态转换的钩子函数. 返回第一个失败的回调的错误或者处理函数的错误
+func (s *StateMachine[T, S]) complete(ctx context.Context, action string, from S, transition *Transition[T, S], handlerErr error) error {
+	if to := s.stateHolder.State(); to != from {
+		if err := s.invokeHooks(ctx, fmt.Sprintf("exit callback of state %v", from), s.definition.onExit[from]); err != nil {
+			return err
+		}
+		if err := s.invokeHooks(ctx, fmt.Sprintf("enter callback of state %v", to), s.definition.onEnter[to]); err != nil {
 			return err
 		}
-		return fmt.Errorf("failed to invoke %s action handler during state transition: %w", action, err)
 	}
-	s.stateHolder.UpdateState(transition.to, nil)
-	return s.triggerAfterHook(ctx, transition)
+	if handlerErr == nil {
+		if err := s.invokeHooks(ctx, "success hook", transition.successHooks); err != nil {
+			return err
+		}
+	} else if err := s.invokeHooks(ctx, "failure hook", transition.failureHooks); err != nil {
+		return err
+	}
+	if err := s.invokeHooks(ctx, "after hook", transition.afterHooks); err != nil {
+		return err
+	}
+	if handlerErr != nil {
+		return fmt.Errorf("failed to invoke %s action handler during state transition: %w", action, handlerErr)
+	}
+	return nil
+}
+
+// record 将状态转换交给所有的记录器
+func (s *StateMachine[T, S]) record(ctx context.Context, action string, from S, startedAt time.Time, err error) {
+	if len(s.definition.recorders) == 0 {
+		return
+	}
+	record := TransitionRecord[T, S]{
+		StateHolder: s.stateHolder,
+		Action:      action,
+		From:        from,
+		To:          s.stateHolder.State(),
+		Err:         err,
+		Actor:       ActorFromContext(ctx),
+		StartedAt:   startedAt,
+		Duration:    time.Since(startedAt),
+	}
+	for _, recorder := range s.definition.recorders {
+		recorder.Record(ctx, record)
+	}
 }
 
-func (s *StateMachine[T, S]) triggerAfterHook(ctx context.Context, transition *Transition[T, S]) error {
-	for _, hook := range transition.afterHooks {
-		err := hook.Invoke(ctx, s.stateHolder)
-		if err != nil {
-			return fmt.Errorf("failed to invoke success hook: %w", err)
+func (s *StateMachine[T, S]) invokeHooks(ctx context.Context, name string, hooks []ActionHandler[T, S]) error {
+	for _, hook := range hooks {
+		if err := hook.Invoke(ctx, s.stateHolder); err != nil {
+			return fmt.Errorf("failed to invoke %s: %w", name, err)
 		}
 	}
 	return nil
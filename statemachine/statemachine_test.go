@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestStateMachine_FromAndGuard(t *testing.T) {
+	paid := true
+	newMachine := func(o *order) StateMachine[*order, string] {
+		machine := NewStateMachine[*order, string](o)
+		machine.AddTransition("cancel", NewTransitionBuilder[*order, string]().
+			From("created", "paid").To("cancelled").
+			Guard("not_shipped", func(ctx context.Context, o *order) (bool, error) {
+				return o.Reason != "shipped", nil
+			}).
+			Guard("refunded", func(ctx context.Context, o *order) (bool, error) {
+				return !paid, nil
+			}).Build())
+		return machine
+	}
+
+	o := &order{Status: "paid"}
+	machine := newMachine(o)
+	err := machine.Submit(context.Background(), "cancel")
+	var rejected *ErrGuardRejected
+	assert.T(t, errors.As(err, &rejected))
+	assert.Equal(t, "refunded", rejected.Guard)
+	assert.Equal(t, "cancel", rejected.Action)
+	assert.Equal(t, "paid", o.Status)
+
+	paid = false
+	assert.Equal(t, nil, machine.Submit(context.Background(), "cancel"))
+	assert.Equal(t, "cancelled", o.Status)
+
+	o = &order{Status: "created"}
+	machine = newMachine(o)
+	assert.Equal(t, nil, machine.Submit(context.Background(), "cancel"))
+	assert.Equal(t, "cancelled", o.Status)
+	assert.NotEqual(t, nil, machine.Submit(context.Background(), "cancel"))
+}
+
+func TestStateMachine_DefaultFrom(t *testing.T) {
+	o := &order{}
+	machine := NewStateMachine[*order, string](o)
+	machine.AddTransition("create", NewTransitionBuilder[*order, string]().To("created").Build())
+	machine.AddTransition("pay", NewTransitionBuilder[*order, string]().
+		From("created", "pending").From("created").To("paid").Build())
+	assert.Equal(t, nil, machine.Submits(context.Background(), "create", "pay"))
+	assert.Equal(t, "paid", o.Status)
+
+	o = &order{Status: "pending"}
+	machine = NewStateMachine[*order, string](o)
+	machine.AddTransition("pay", NewTransitionBuilder[*order, string]().
+		From("created", "pending").From("created").To("paid").Build())
+	assert.NotEqual(t, nil, machine.Submit(context.Background(), "pay"))
+	assert.Equal(t, "pending", o.Status)
+}
+
+func TestStateMachine_Hooks(t *testing.T) {
+	var calls []string
+	hook := func(name string) ActionHandler[*order, string] {
+		return ActionHandlerFunc[*order, string](func(ctx context.Context, o *order) error {
+			calls = append(calls, name)
+			return nil
+		})
+	}
+	definition := NewStateMachineDefinition[*order, string]("created").
+		AddTransition("pay", NewTransitionBuilder[*order, string]().
+			From("created").To("paid").Failed("pay_failed").
+			BeforeHook(hook("before")).
+			Handler(ActionHandlerFunc[*order, string](func(ctx context.Context, o *order) error {
+				if o.Reason == "insufficient" {
+					return errors.New("insufficient balance")
+				}
+				return nil
+			})).
+			OnSuccess(hook("success")).
+			OnFailure(hook("failure")).
+			AfterHook(hook("after")).Build()).
+		OnExit("created", hook("exit created")).
+		OnEnter("paid", hook("enter paid")).
+		OnEnter("pay_failed", hook("enter pay_failed"))
+
+	machine := definition.NewStateMachine(&order{Status: "created"})
+	assert.Equal(t, nil, machine.Submit(context.Background(), "pay"))
+	assert.Equal(t, []string{"before", "exit created", "enter paid", "success", "after"}, calls)
+
+	calls = nil
+	machine = definition.NewStateMachine(&order{Status: "created", Reason: "insufficient"})
+	assert.NotEqual(t, nil, machine.Submit(context.Background(), "pay"))
+	assert.Equal(t, []string{"before", "exit created", "enter pay_failed", "failure", "after"}, calls)
+}
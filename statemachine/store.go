@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrConcurrentTransition 状态持有者在加载之后被其他请求修改, 本次状态转换没有被保存
+var ErrConcurrentTransition = errors.New("concurrent state transition")
+
+type (
+	// VersionedStateHolder 带有版本号的状态持有者. 保存时通过版本号检查状态持有者是否被其他请求修改
+	VersionedStateHolder[S comparable] interface {
+		StateHolder[S]
+		StateVersion() int64
+		SetStateVersion(int64)
+	}
+
+	// StateStore 状态持有者的存储
+	StateStore[T StateHolder[S], S comparable] interface {
+		// Load 加载 id 对应的状态持有者
+		Load(ctx context.Context, id any) (T, error)
+		// Save 保存状态转换后的状态持有者. 状态持有者在加载之后被其他请求修改时返回 ErrConcurrentTransition
+		Save(ctx context.Context, stateHolder T) error
+	}
+
+	// PersistentStateMachine 持久化的状态机. 每次执行动作时从 StateStore 加载状态持有者, 完成状态转换后保存.
+	// 多个副本同时对同一个状态持有者执行动作时只有一个能够保存成功. 第一次 Submit 之后不可再修改状态转换以及回调
+	PersistentStateMachine[T StateHolder[S], S comparable] struct {
+		store      StateStore[T, S]
+		definition *StateMachineDefinition[T, S]
+	}
+)
+
+// AddTransition 添加状态转换的映射. 与 StateMachine.AddTransition 相同
+func (p *PersistentStateMachine[T, S]) AddTransition(action string, transition *Transition[T, S]) *PersistentStateMachine[T, S] {
+	p.definition.AddTransition(action, transition)
+	return p
+}
+
+// AddRecorder 添加状态转换的记录器. 记录的错误包含处理函数的错误以及保存时的错误
+func (p *PersistentStateMachine[T, S]) AddRecorder(recorders ...TransitionRecorder[T, S]) *PersistentStateMachine[T, S] {
+	p.definition.AddRecorder(recorders...)
+	return p
+}
+
+// OnEnter 添加进入状态时的回调. 与 StateMachine.OnEnter 相同, 在保存成功后调用
+func (p *PersistentStateMachine[T, S]) OnEnter(state S, callback ActionHandler[T, S]) *PersistentStateMachine[T, S] {
+	p.definition.OnEnter(state, callback)
+	return p
+}
+
+// OnExit 添加离开状态时的回调. 与 StateMachine.OnExit 相同, 在保存成功后调用
+func (p *PersistentStateMachine[T, S]) OnExit(state S, callback ActionHandler[T, S]) *PersistentStateMachine[T, S] {
+	p.definition.OnExit(state, callback)
+	return p
+}
+
+// Submit 加载 id 对应的状态持有者并执行一个动作. 处理函数失败时同样保存失败后的状态.
+// 保存成功后才执行状态的回调以及 successHooks, failureHooks, afterHooks, 保存时发生冲突返回 ErrConcurrentTransition
+func (p *PersistentStateMachine[T, S]) Submit(ctx context.Context, id any, action string) (T, error) {
+	stateHolder, err := p.store.Load(ctx, id)
+	if err != nil {
+		return stateHolder, err
+	}
+	machine := p.definition.NewStateMachine(stateHolder)
+	transition, err := machine.transition(ctx, action)
+	if err != nil {
+		return stateHolder, err
+	}
+	if err := machine.invokeHooks(ctx, "before hook", transition.beforeHooks); err != nil {
+		return stateHolder, err
+	}
+	from, startedAt := stateHolder.State(), time.Now()
+	handlerErr := machine.transit(ctx, transition)
+	if err := p.store.Save(ctx, stateHolder); err != nil {
+		machine.record(ctx, action, from, startedAt, errors.Join(handlerErr, err))
+		return stateHolder, err
+	}
+	machine.record(ctx, action, from, startedAt, handlerErr)
+	return stateHolder, machine.complete(ctx, action, from, transition, handlerErr)
+}
+
+// NewPersistentStateMachine 创建持久化的状态机. 状态转换以及回调通过返回的状态机添加,
+// 需要校验或者导出状态图时使用 StateMachineDefinition.Persistent
+func NewPersistentStateMachine[T StateHolder[S], S comparable](store StateStore[T, S]) *PersistentStateMachine[T, S] {
+	var initial S
+	return NewStateMachineDefinition[T, S](initial).Persistent(store)
+}
+
+// Persistent 使用定义创建持久化的状态机. 多个状态机共享同一个定义, 通过状态机添加的状态转换以及回调同样修改该定义
+func (d *StateMachineDefinition[T, S]) Persistent(store StateStore[T, S]) *PersistentStateMachine[T, S] {
+	return &PersistentStateMachine[T, S]{
+		store:      store,
+		definition: d,
+	}
+}
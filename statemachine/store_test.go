@@ -0,0 +1,129 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/bmizerany/assert"
+	gormMysqlDriver "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+type order struct {
+	ID      int64
+	Status  string
+	Version int64
+	Reason  string
+}
+
+func (o *order) State() string {
+	return o.Status
+}
+
+func (o *order) UpdateState(status string, err error) {
+	o.Status = status
+	if err != nil {
+		o.Reason = err.Error()
+	}
+}
+
+func (o *order) StateVersion() int64 {
+	return o.Version
+}
+
+func (o *order) SetStateVersion(version int64) {
+	o.Version = version
+}
+
+func newMockGormDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	assert.Equal(t, nil, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	gormDB, err := gorm.Open(gormMysqlDriver.New(gormMysqlDriver.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+	assert.Equal(t, nil, err)
+	return gormDB, mock
+}
+
+func newOrderStateMachine(db *gorm.DB, handler ActionHandler[*order, string]) *PersistentStateMachine[*order, string] {
+	return NewPersistentStateMachine[*order, string](NewGormStateStore[*order, string](db)).
+		AddTransition("pay", NewTransitionBuilder[*order, string]().
+			From("created").To("paid").Failed("pay_failed").Handler(handler).Build())
+}
+
+func expectLoadOrder(mock sqlmock.Sqlmock, status string, version int64) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `orders` WHERE `id` = ? LIMIT ?")).
+		WithArgs(int64(1), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "version", "reason"}).AddRow(1, status, version, ""))
+}
+
+func TestPersistentStateMachine_Submit(t *testing.T) {
+	db, mock := newMockGormDB(t)
+	machine := newOrderStateMachine(db, nil)
+
+	expectLoadOrder(mock, "created", 3)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `orders` SET `status`=?,`version`=?,`reason`=? WHERE `version` = ? AND `id` = ?")).
+		WithArgs("paid", int64(4), "", int64(3), int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	o, err := machine.Submit(context.Background(), int64(1), "pay")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &order{ID: 1, Status: "paid", Version: 4}, o)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
+
+func TestPersistentStateMachine_ConcurrentTransition(t *testing.T) {
+	db, mock := newMockGormDB(t)
+	machine := newOrderStateMachine(db, ActionHandlerFunc[*order, string](func(ctx context.Context, o *order) error {
+		return errors.New("insufficient balance")
+	}))
+
+	expectLoadOrder(mock, "created", 3)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `orders`")).
+		WithArgs("pay_failed", int64(4), "insufficient balance", int64(3), int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	o, err := machine.Submit(context.Background(), int64(1), "pay")
+	assert.Equal(t, ErrConcurrentTransition, err)
+	assert.Equal(t, int64(3), o.Version)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
+
+func TestStateMachineDefinition_Persistent(t *testing.T) {
+	db, mock := newMockGormDB(t)
+	var entered []string
+	definition := NewStateMachineDefinition[*order, string]("created").
+		AddTransition("pay", NewTransitionBuilder[*order, string]().From("created").To("paid").Build()).
+		Final("paid").
+		OnEnter("paid", ActionHandlerFunc[*order, string](func(ctx context.Context, o *order) error {
+			entered = append(entered, o.Status)
+			return nil
+		}))
+	assert.Equal(t, nil, definition.Validate())
+	machine := definition.Persistent(NewGormStateStore[*order, string](db))
+
+	expectLoadOrder(mock, "created", 3)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `orders`")).
+		WithArgs("paid", int64(4), "", int64(3), int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	o, err := machine.Submit(context.Background(), int64(1), "pay")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "paid", o.Status)
+	assert.Equal(t, []string{"paid"}, entered)
+	assert.Equal(t, nil, mock.ExpectationsWereMet())
+}
@@ -1,12 +1,31 @@
 package pkg
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"slices"
+)
 
 type (
 	Transition[T StateHolder[S], S comparable] struct {
-		from, to, failed S
-		handler          ActionHandler[T, S]
-		afterHooks       []ActionHandler[T, S]
+		from       []S
+		to, failed S
+		// hasFailed 是否指定了失败后的状态
+		hasFailed    bool
+		guards       []guard[T, S]
+		beforeHooks  []ActionHandler[T, S]
+		handler      ActionHandler[T, S]
+		successHooks []ActionHandler[T, S]
+		failureHooks []ActionHandler[T, S]
+		afterHooks   []ActionHandler[T, S]
+	}
+
+	// GuardFunc 执行动作的前置条件. 返回 false 时拒绝执行动作
+	GuardFunc[T StateHolder[S], S comparable] func(ctx context.Context, stateHolder T) (bool, error)
+
+	guard[T StateHolder[S], S comparable] struct {
+		name  string
+		check GuardFunc[T, S]
 	}
 
 	TransitionBuilder[T StateHolder[S], S comparable] struct {
@@ -23,6 +42,42 @@ func (t *Transition[T, S]) getHandler() ActionHandler[T, S] {
 	})
 }
 
+// allowFrom 返回是否可以从 state 执行动作
+func (t *Transition[T, S]) allowFrom(state S) bool {
+	return slices.Contains(t.sources(), state)
+}
+
+// sources 返回可以执行动作的状态. 未指定时为状态的零值
+func (t *Transition[T, S]) sources() []S {
+	if len(t.from) == 0 {
+		var zero S
+		return []S{zero}
+	}
+	return t.from
+}
+
+// targets 返回执行动作后可能的状态
+func (t *Transition[T, S]) targets() []S {
+	if t.hasFailed {
+		return []S{t.to, t.failed}
+	}
+	return []S{t.to}
+}
+
+// checkGuards 依次检查前置条件. 前置条件不满足时返回 *ErrGuardRejected
+func (t *Transition[T, S]) checkGuards(ctx context.Context, action string, stateHolder T) error {
+	for _, guard := range t.guards {
+		ok, err := guard.check(ctx, stateHolder)
+		if err != nil {
+			return fmt.Errorf("failed to check guard %s of action %s: %w", guard.name, action, err)
+		}
+		if !ok {
+			return &ErrGuardRejected{Action: action, Guard: guard.name}
+		}
+	}
+	return nil
+}
+
 func NewTransitionBuilder[T StateHolder[S], S comparable]() *TransitionBuilder[T, S] {
 	return &TransitionBuilder[T, S]{
 		transition: &Transition[T, S]{},
@@ -34,13 +89,22 @@ func (t *TransitionBuilder[T, S]) To(to S) *TransitionBuilder[T, S] {
 	return t
 }
 
-func (t *TransitionBuilder[T, S]) From(from S) *TransitionBuilder[T, S] {
-	t.transition.from = from
+// From 执行动作前的状态. 可以指定多个状态, 处于其中任意一个状态时都可以执行动作.
+// 多次调用时以最后一次为准, 未指定时为状态的零值
+func (t *TransitionBuilder[T, S]) From(from ...S) *TransitionBuilder[T, S] {
+	t.transition.from = slices.Clone(from)
 	return t
 }
 
 func (t *TransitionBuilder[T, S]) Failed(failed S) *TransitionBuilder[T, S] {
 	t.transition.failed = failed
+	t.transition.hasFailed = true
+	return t
+}
+
+// Guard 添加执行动作的前置条件. 在处理函数之前按照添加的顺序检查, name 用于标识被拒绝的前置条件
+func (t *TransitionBuilder[T, S]) Guard(name string, check GuardFunc[T, S]) *TransitionBuilder[T, S] {
+	t.transition.guards = append(t.transition.guards, guard[T, S]{name: name, check: check})
 	return t
 }
 
@@ -49,6 +113,25 @@ func (t *TransitionBuilder[T, S]) Handler(handler ActionHandler[T, S]) *Transiti
 	return t
 }
 
+// BeforeHook 添加执行处理函数之前的钩子函数. 在前置条件检查通过之后执行, 返回错误时不执行动作并且不更新状态
+func (t *TransitionBuilder[T, S]) BeforeHook(handler ActionHandler[T, S]) *TransitionBuilder[T, S] {
+	t.transition.beforeHooks = append(t.transition.beforeHooks, handler)
+	return t
+}
+
+// OnSuccess 添加处理函数成功后的钩子函数
+func (t *TransitionBuilder[T, S]) OnSuccess(handler ActionHandler[T, S]) *TransitionBuilder[T, S] {
+	t.transition.successHooks = append(t.transition.successHooks, handler)
+	return t
+}
+
+// OnFailure 添加处理函数失败后的钩子函数. 此时状态已经更新为 failed 状态
+func (t *TransitionBuilder[T, S]) OnFailure(handler ActionHandler[T, S]) *TransitionBuilder[T, S] {
+	t.transition.failureHooks = append(t.transition.failureHooks, handler)
+	return t
+}
+
+// AfterHook 添加执行动作后的钩子函数. 无论处理函数成功或者失败都会执行, 在 OnSuccess 以及 OnFailure 之后
 func (t *TransitionBuilder[T, S]) AfterHook(handler ActionHandler[T, S]) *TransitionBuilder[T, S] {
 	t.transition.afterHooks = append(t.transition.afterHooks, handler)
 	return t